@@ -0,0 +1,430 @@
+package cmap
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// compile check
+var (
+	_ Cache       = (*arenaCache)(nil)
+	_ rangeCache  = (*arenaCache)(nil)
+	_ reasonCache = (*arenaCache)(nil)
+	_ expiryCache = (*arenaCache)(nil)
+)
+
+const (
+	arenaKindDead byte = iota
+	arenaKindBytes
+	arenaKindString
+	arenaKindSlot
+)
+
+const (
+	// kind(1) | key length(4) | value length(4) | expire(8)
+	arenaHeaderSize = 17
+
+	arenaEntrySizeHint = 64
+	arenaMinCompact    = 64 * 1024
+	// offsets are uint32, bounded by int on 32bit platforms
+	arenaMaxSize = math.MaxUint32 & uint64(math.MaxInt)
+)
+
+// arenaCache stores keys and values serialized in a single []byte arena, indexed by pointer-free
+// map[uint64]uint32 (key hash to offset), so GC does not scan entries.
+// []byte and string values are stored as is, other values are held in slots referenced from arena.
+// keys whose hash collides with another key are indexed by overflow map.
+// removed and replaced entries leave garbage in arena, which is compacted when it exceeds live entries.
+// each arena holds up to 4GiB, when full, entries are evicted from the oldest written as ring buffer.
+type arenaCache struct {
+	mutex    *sync.RWMutex
+	arena    []byte
+	maxSize  int
+	index    map[uint64]uint32
+	overflow map[string]uint32
+	hash     func(string) uint64
+	slots    []interface{}
+	free     []uint32
+	count    int
+	garbage  int
+	ttlCount int
+	onEvict  OnEvictFunc
+	onReason func(EvictReason)
+}
+
+func (c *arenaCache) Lock() {
+	c.mutex.Lock()
+}
+
+func (c *arenaCache) TryLock() bool {
+	return c.mutex.TryLock()
+}
+
+func (c *arenaCache) RLock() {
+	c.mutex.RLock()
+}
+
+func (c *arenaCache) TryRLock() bool {
+	return c.mutex.TryRLock()
+}
+
+func (c *arenaCache) Unlock() {
+	c.mutex.Unlock()
+}
+
+func (c *arenaCache) RUnlock() {
+	c.mutex.RUnlock()
+}
+
+func (c *arenaCache) SetOnEvict(fn OnEvictFunc) {
+	c.onEvict = fn
+}
+
+func (c *arenaCache) SetOnEvictReason(fn func(EvictReason)) {
+	c.onReason = fn
+}
+
+// evict reports evicted entry, value is read from arena only when OnEvict is set
+func (c *arenaCache) evict(key string, off uint32, reason EvictReason) {
+	if c.onEvict != nil {
+		c.onEvict(key, c.value(off), reason)
+		return
+	}
+	if c.onReason != nil {
+		c.onReason(reason)
+	}
+}
+
+func (c *arenaCache) kind(off uint32) byte {
+	return c.arena[off]
+}
+
+func (c *arenaCache) keyLen(off uint32) uint32 {
+	return binary.LittleEndian.Uint32(c.arena[off+1:])
+}
+
+func (c *arenaCache) valueLen(off uint32) uint32 {
+	return binary.LittleEndian.Uint32(c.arena[off+5:])
+}
+
+func (c *arenaCache) expire(off uint32) int64 {
+	return int64(binary.LittleEndian.Uint64(c.arena[off+9:]))
+}
+
+func (c *arenaCache) entrySize(off uint32) uint32 {
+	return arenaHeaderSize + c.keyLen(off) + c.valueLen(off)
+}
+
+func (c *arenaCache) keyBytes(off uint32) []byte {
+	start := off + arenaHeaderSize
+	return c.arena[start : start+c.keyLen(off)]
+}
+
+func (c *arenaCache) isExpired(off uint32, now int64) bool {
+	e := c.expire(off)
+	return 0 < e && e <= now
+}
+
+func (c *arenaCache) value(off uint32) interface{} {
+	start := off + arenaHeaderSize + c.keyLen(off)
+	data := c.arena[start : start+c.valueLen(off)]
+	switch c.kind(off) {
+	case arenaKindBytes:
+		return append([]byte(nil), data...)
+	case arenaKindString:
+		return string(data)
+	case arenaKindSlot:
+		return c.slots[binary.LittleEndian.Uint32(data)]
+	}
+	return nil
+}
+
+func (c *arenaCache) lookup(key string) (uint32, bool) {
+	if off, ok := c.index[c.hash(key)]; ok && string(c.keyBytes(off)) == key {
+		return off, true
+	}
+	if len(c.overflow) < 1 {
+		return 0, false
+	}
+	off, ok := c.overflow[key]
+	return off, ok
+}
+
+func (c *arenaCache) encode(value interface{}) (byte, []byte) {
+	switch v := value.(type) {
+	case []byte:
+		return arenaKindBytes, v
+	case string:
+		return arenaKindString, []byte(v)
+	}
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, c.allocSlot(value))
+	return arenaKindSlot, data
+}
+
+func (c *arenaCache) allocSlot(value interface{}) uint32 {
+	if n := len(c.free); 0 < n {
+		i := c.free[n-1]
+		c.free = c.free[:n-1]
+		c.slots[i] = value
+		return i
+	}
+	c.slots = append(c.slots, value)
+	return uint32(len(c.slots) - 1)
+}
+
+func (c *arenaCache) releaseSlot(off uint32) {
+	start := off + arenaHeaderSize + c.keyLen(off)
+	i := binary.LittleEndian.Uint32(c.arena[start:])
+	c.slots[i] = nil
+	c.free = append(c.free, i)
+}
+
+func (c *arenaCache) Set(key string, value interface{}) {
+	c.set(key, value, 0)
+}
+
+func (c *arenaCache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		c.set(key, value, 0)
+		return
+	}
+	c.set(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+func (c *arenaCache) set(key string, value interface{}, expire int64) {
+	if off, ok := c.lookup(key); ok {
+		reason := EvictReasonReplaced
+		if c.isExpired(off, time.Now().UnixNano()) {
+			reason = EvictReasonExpired
+		}
+		c.evict(key, off, reason)
+		c.kill(off)
+	}
+	kind, data := c.encode(value)
+	size := arenaHeaderSize + len(key) + len(data)
+	if c.maxSize < size {
+		// never fits, only []byte or string value can be this large
+		c.report(key, value, EvictReasonCapacity)
+		return
+	}
+	c.maybeCompact(size)
+	if c.maxSize < len(c.arena)+size {
+		c.evictOldest(len(c.arena) - c.garbage + size - c.maxSize)
+		c.compact(size)
+	}
+
+	off := c.append(kind, []byte(key), data, expire)
+	c.count += 1
+	if 0 < expire {
+		c.ttlCount += 1
+	}
+	h := c.hash(key)
+	if prev, ok := c.index[h]; ok && c.kind(prev) != arenaKindDead && string(c.keyBytes(prev)) != key {
+		c.overflow[key] = off
+		return
+	}
+	c.index[h] = off
+}
+
+func (c *arenaCache) append(kind byte, key, data []byte, expire int64) uint32 {
+	off := uint32(len(c.arena))
+	header := [arenaHeaderSize]byte{kind}
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(header[5:], uint32(len(data)))
+	binary.LittleEndian.PutUint64(header[9:], uint64(expire))
+	c.arena = append(c.arena, header[:]...)
+	c.arena = append(c.arena, key...)
+	c.arena = append(c.arena, data...)
+	return off
+}
+
+// kill marks entry as dead and removes it from index
+func (c *arenaCache) kill(off uint32) {
+	key := string(c.keyBytes(off))
+	if o, ok := c.overflow[key]; ok && o == off {
+		delete(c.overflow, key)
+	} else {
+		delete(c.index, c.hash(key))
+	}
+	if 0 < c.expire(off) {
+		c.ttlCount -= 1
+	}
+	if c.kind(off) == arenaKindSlot {
+		c.releaseSlot(off)
+	}
+	c.arena[off] = arenaKindDead
+	c.count -= 1
+	c.garbage += int(c.entrySize(off))
+}
+
+// maybeCompact copies live entries to new arena when garbage exceeds live entries
+func (c *arenaCache) maybeCompact(size int) {
+	if c.garbage < arenaMinCompact || c.garbage*2 < len(c.arena) {
+		return
+	}
+	c.compact(size)
+}
+
+// evictOldest evicts live entries from the start of arena until size bytes are freed
+func (c *arenaCache) evictOldest(size int) {
+	freed := 0
+	for off := uint32(0); freed < size && int(off) < len(c.arena); off += c.entrySize(off) {
+		if c.kind(off) == arenaKindDead {
+			continue
+		}
+		freed += int(c.entrySize(off))
+		c.evict(string(c.keyBytes(off)), off, EvictReasonCapacity)
+		c.kill(off)
+	}
+}
+
+func (c *arenaCache) compact(size int) {
+	arena := make([]byte, 0, len(c.arena)-c.garbage+size)
+	for off := uint32(0); int(off) < len(c.arena); off += c.entrySize(off) {
+		if c.kind(off) == arenaKindDead {
+			continue
+		}
+		newOff := uint32(len(arena))
+		arena = append(arena, c.arena[off:off+c.entrySize(off)]...)
+
+		key := string(c.keyBytes(off))
+		if o, ok := c.overflow[key]; ok && o == off {
+			c.overflow[key] = newOff
+		} else {
+			c.index[c.hash(key)] = newOff
+		}
+	}
+	c.arena = arena
+	c.garbage = 0
+}
+
+func (c *arenaCache) Get(key string) (interface{}, bool) {
+	off, ok := c.lookup(key)
+	if ok != true || c.isExpired(off, time.Now().UnixNano()) {
+		return nil, false
+	}
+	return c.value(off), true
+}
+
+func (c *arenaCache) TTL(key string) (time.Duration, bool) {
+	off, ok := c.lookup(key)
+	if ok != true {
+		return 0, false
+	}
+	e := c.expire(off)
+	if e == 0 {
+		return 0, false
+	}
+	return time.Duration(e - time.Now().UnixNano()), true
+}
+
+func (c *arenaCache) Remove(key string) (interface{}, bool) {
+	off, ok := c.lookup(key)
+	if ok != true {
+		return nil, false
+	}
+	v := c.value(off)
+	expired := c.isExpired(off, time.Now().UnixNano())
+	c.kill(off)
+
+	if expired {
+		c.report(key, v, EvictReasonExpired)
+		return nil, false
+	}
+	c.report(key, v, EvictReasonRemoved)
+	return v, true
+}
+
+// report is evict of value already read from arena
+func (c *arenaCache) report(key string, value interface{}, reason EvictReason) {
+	if c.onEvict != nil {
+		c.onEvict(key, value, reason)
+		return
+	}
+	if c.onReason != nil {
+		c.onReason(reason)
+	}
+}
+
+func (c *arenaCache) RemoveExpired() int {
+	if c.ttlCount < 1 {
+		return 0
+	}
+
+	now := time.Now().UnixNano()
+	removed := 0
+	for off := uint32(0); int(off) < len(c.arena); off += c.entrySize(off) {
+		if c.kind(off) == arenaKindDead || c.isExpired(off, now) != true {
+			continue
+		}
+		c.evict(string(c.keyBytes(off)), off, EvictReasonExpired)
+		c.kill(off)
+		removed += 1
+	}
+	return removed
+}
+
+func (c *arenaCache) Len() int {
+	return c.count
+}
+
+func (c *arenaCache) Keys() []string {
+	keys := make([]string, 0, c.count)
+	c.rangeEntries(func(off uint32) bool {
+		keys = append(keys, string(c.keyBytes(off)))
+		return true
+	})
+	return keys
+}
+
+func (c *arenaCache) Range(fn RangeFunc) {
+	c.rangeEntries(func(off uint32) bool {
+		return fn(string(c.keyBytes(off)), c.value(off))
+	})
+}
+
+func (c *arenaCache) rangeEntries(fn func(off uint32) bool) {
+	now := time.Now().UnixNano()
+	for off := uint32(0); int(off) < len(c.arena); off += c.entrySize(off) {
+		if c.kind(off) == arenaKindDead || c.isExpired(off, now) {
+			continue
+		}
+		if fn(off) != true {
+			return
+		}
+	}
+}
+
+func (c *arenaCache) rangeExpiry(fn func(key string, value interface{}, expireAt int64) bool) {
+	for off := uint32(0); int(off) < len(c.arena); off += c.entrySize(off) {
+		if c.kind(off) == arenaKindDead {
+			continue
+		}
+		if fn(string(c.keyBytes(off)), c.value(off), c.expire(off)) != true {
+			return
+		}
+	}
+}
+
+// NewArenaCache returns Cache that stores []byte and string entries off the GC scanned heap.
+// other values are accepted but held as interface{}, so they are scanned by GC as in default cache.
+// Get returns copy of stored value, so modifying returned []byte does not affect the cache.
+func NewArenaCache(capacity int) Cache {
+	return newArenaCache(capacity)
+}
+
+func newArenaCache(capacity int) *arenaCache {
+	return &arenaCache{
+		mutex:    new(sync.RWMutex),
+		arena:    make([]byte, 0, capacity*arenaEntrySizeHint),
+		maxSize:  int(arenaMaxSize),
+		index:    make(map[uint64]uint32, capacity),
+		overflow: make(map[string]uint32),
+		hash:     xxhash.Sum64String,
+	}
+}
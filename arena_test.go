@@ -0,0 +1,233 @@
+package cmap
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestArenaCacheBytes(t *testing.T) {
+	c := newArenaCache(16)
+	c.Set("foo", []byte("bar"))
+	c.Set("str", "baz")
+
+	v, ok := c.Get("foo")
+	if ok != true || string(v.([]byte)) != "bar" {
+		t.Errorf("foo = bar: %v", v)
+	}
+	v.([]byte)[0] = 'x'
+	if v, _ := c.Get("foo"); string(v.([]byte)) != "bar" {
+		t.Errorf("returned value is copy: %s", v)
+	}
+	if v, _ := c.Get("str"); v != "baz" {
+		t.Errorf("string kept as string: %v", v)
+	}
+}
+
+func TestArenaCacheSlot(t *testing.T) {
+	type value struct {
+		fn func()
+	}
+	c := newArenaCache(16)
+	for i := 0; i < 10; i += 1 {
+		c.Set("foo", value{func() {}})
+	}
+	if v, ok := c.Get("foo"); ok != true || v.(value).fn == nil {
+		t.Errorf("value not encodable kept: %v", v)
+	}
+	if len(c.slots) != 1 {
+		t.Errorf("slot reused: %d", len(c.slots))
+	}
+	c.Set("foo", []byte("bar"))
+	if c.slots[0] != nil || len(c.free) != 1 {
+		t.Errorf("slot released: %v %v", c.slots, c.free)
+	}
+	c.Set("bar", 1)
+	if v, _ := c.Remove("bar"); v != 1 {
+		t.Errorf("bar = 1: %v", v)
+	}
+}
+
+func TestArenaCacheEvictReason(t *testing.T) {
+	newCMap := func(funcs ...cmapOptionFunc) (*CMap, *arenaCache) {
+		funcs = append(funcs, WithSlabSize(1), WithCacheFactory(func(capacity int) Cache {
+			return NewArenaCache(capacity)
+		}))
+		c := New(funcs...)
+		return c, c.loadSlab().Shards()[0].Cache.(*arenaCache)
+	}
+
+	c, a := newCMap()
+	if a.onEvict != nil || a.onReason == nil {
+		t.Errorf("values not read on replace without OnEvict")
+	}
+	c.SetWithTTL("foo", []byte("bar"), time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	c.RemoveExpired()
+	if s := c.Stats(); s.Evictions != 1 {
+		t.Errorf("eviction counted: %d", s.Evictions)
+	}
+
+	c, a = newCMap(WithOnEvict(func(string, interface{}, EvictReason) {}))
+	if a.onEvict == nil {
+		t.Errorf("values reported with OnEvict")
+	}
+}
+
+func TestArenaCacheCollision(t *testing.T) {
+	c := newArenaCache(16)
+	c.hash = func(string) uint64 {
+		return 1
+	}
+
+	for i := 0; i < 10; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+	if c.Len() != 10 {
+		t.Errorf("10 keys set: %d", c.Len())
+	}
+	if len(c.overflow) != 9 {
+		t.Errorf("colliding keys in overflow: %d", len(c.overflow))
+	}
+	for i := 0; i < 10; i += 1 {
+		if v, ok := c.Get(strconv.Itoa(i)); ok != true || v != i {
+			t.Errorf("key %d = %d: %v", i, i, v)
+		}
+	}
+
+	c.Remove("0")
+	c.Remove("5")
+	c.Set("3", 33)
+	if _, ok := c.Get("0"); ok {
+		t.Errorf("0 removed")
+	}
+	if _, ok := c.Get("5"); ok {
+		t.Errorf("5 removed")
+	}
+	if v, _ := c.Get("3"); v != 33 {
+		t.Errorf("3 updated: %v", v)
+	}
+	if c.Len() != 8 || len(c.Keys()) != 8 {
+		t.Errorf("8 keys remain: %d %v", c.Len(), c.Keys())
+	}
+}
+
+func TestArenaCacheCompact(t *testing.T) {
+	c := newArenaCache(16)
+	value := make([]byte, 1024)
+	for i := 0; i < 1000; i += 1 {
+		c.Set(strconv.Itoa(i%10), value)
+	}
+	if c.Len() != 10 {
+		t.Errorf("10 keys: %d", c.Len())
+	}
+	if 200*1024 < len(c.arena) {
+		t.Errorf("garbage compacted: arena %d bytes", len(c.arena))
+	}
+	for i := 0; i < 10; i += 1 {
+		if v, ok := c.Get(strconv.Itoa(i)); ok != true || len(v.([]byte)) != 1024 {
+			t.Errorf("key %d exists after compaction", i)
+		}
+	}
+
+	c.SetWithTTL("ttl", value, time.Hour)
+	if ttl, ok := c.TTL("ttl"); ok != true || ttl <= 0 {
+		t.Errorf("ttl kept: %s", ttl)
+	}
+	if _, ok := c.TTL("0"); ok {
+		t.Errorf("no ttl")
+	}
+}
+
+func TestArenaCacheFull(t *testing.T) {
+	t.Run("evict oldest", func(tt *testing.T) {
+		c := newArenaCache(16)
+		c.maxSize = 64 * 1024
+		evicted := make([]string, 0)
+		c.SetOnEvict(func(key string, value interface{}, reason EvictReason) {
+			if reason != EvictReasonCapacity {
+				tt.Errorf("capacity reason: %s %s", key, reason)
+			}
+			evicted = append(evicted, key)
+		})
+		value := make([]byte, 1024)
+		for i := 0; i < 1000; i += 1 {
+			c.Set(strconv.Itoa(i), value)
+		}
+		if c.maxSize < len(c.arena) {
+			tt.Errorf("arena within max size: %d", len(c.arena))
+		}
+		if len(evicted) == 0 || evicted[0] != "0" {
+			tt.Fatalf("oldest evicted first: %v", evicted)
+		}
+		if c.Len()+len(evicted) != 1000 {
+			tt.Errorf("all entries stored or evicted: %d + %d", c.Len(), len(evicted))
+		}
+		if _, ok := c.Get("0"); ok {
+			tt.Errorf("0 evicted")
+		}
+		if v, ok := c.Get("999"); ok != true || len(v.([]byte)) != 1024 {
+			tt.Errorf("newest exists")
+		}
+	})
+	t.Run("too large", func(tt *testing.T) {
+		c := newArenaCache(16)
+		c.maxSize = 1024
+		reasons := make([]EvictReason, 0)
+		c.SetOnEvict(func(key string, value interface{}, reason EvictReason) {
+			reasons = append(reasons, reason)
+		})
+		c.Set("small", 1)
+		c.Set("large", make([]byte, 1024))
+		if _, ok := c.Get("large"); ok {
+			tt.Errorf("large value rejected")
+		}
+		if v, ok := c.Get("small"); ok != true || v != 1 {
+			tt.Errorf("small exists: %v", v)
+		}
+		if len(reasons) != 1 || reasons[0] != EvictReasonCapacity {
+			tt.Errorf("rejected as capacity: %v", reasons)
+		}
+	})
+}
+
+func TestArenaCacheCMap(t *testing.T) {
+	c := New(WithSlabSize(4), WithCacheFactory(func(capacity int) Cache {
+		return NewArenaCache(capacity)
+	}))
+	for i := 0; i < 1000; i += 1 {
+		key := strconv.Itoa(i)
+		c.Set(key, []byte(key))
+	}
+	if c.Len() != 1000 {
+		t.Errorf("1000 keys: %d", c.Len())
+	}
+	if v, _ := c.Get("42"); string(v.([]byte)) != "42" {
+		t.Errorf("42 = 42: %s", v)
+	}
+	c.Resize(16)
+	if v, _ := c.Get("42"); string(v.([]byte)) != "42" {
+		t.Errorf("42 = 42 after resize: %s", v)
+	}
+}
+
+func BenchmarkArenaCacheGC(b *testing.B) {
+	value := make([]byte, 32)
+	run := func(tb *testing.B, c Cache) {
+		for i := 0; i < 1000000; i += 1 {
+			c.Set(strconv.Itoa(i), value)
+		}
+		tb.ResetTimer()
+		for i := 0; i < tb.N; i += 1 {
+			runtime.GC()
+		}
+		runtime.KeepAlive(c)
+	}
+	b.Run("default", func(tb *testing.B) {
+		run(tb, NewDefaultCache(1000000))
+	})
+	b.Run("arena", func(tb *testing.B) {
+		run(tb, NewArenaCache(1000000))
+	})
+}
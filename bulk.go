@@ -0,0 +1,115 @@
+package cmap
+
+import (
+	"strings"
+)
+
+type RemoveWhereFunc func(key string, value interface{}) bool
+
+// Clear removes all entries, expired entries are evicted as EvictReasonExpired.
+func (c *CMap) Clear() {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	for _, m := range s.Shards() {
+		m.Lock()
+		m.RemoveExpired()
+		for _, key := range m.Keys() {
+			m.Remove(key)
+		}
+		c.unlock(m)
+	}
+}
+
+// RemoveAll removes keys, takes each shard lock once.
+func (c *CMap) RemoveAll(keys []string) int {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	removed := 0
+	shards := s.Shards()
+	for _, g := range s.groupByShard(keys) {
+		m := shards[g.index]
+		m.Lock()
+		for _, i := range g.positions {
+			if _, ok := m.Remove(keys[i]); ok {
+				removed += 1
+			}
+		}
+		c.unlock(m)
+	}
+	return removed
+}
+
+func (c *CMap) RemoveByPrefix(prefix string) int {
+	return c.RemoveWhere(func(key string, value interface{}) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// RemoveWhere removes entries that fn returns true, fn is called while holding the shard lock.
+func (c *CMap) RemoveWhere(fn RemoveWhereFunc) int {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	removed := 0
+	keys := make([]string, 0, 64)
+	for _, m := range s.Shards() {
+		keys = keys[:0]
+
+		m.Lock()
+		m.Range(func(key string, value interface{}) bool {
+			if fn(key, value) {
+				keys = append(keys, key)
+			}
+			return true
+		})
+		for _, key := range keys {
+			if _, ok := m.Remove(key); ok {
+				removed += 1
+			}
+		}
+		c.unlock(m)
+	}
+	return removed
+}
+
+// GetMany returns values in the same order as keys, takes each shard lock once.
+func (c *CMap) GetMany(keys []string) ([]interface{}, []bool) {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	values := make([]interface{}, len(keys))
+	exists := make([]bool, len(keys))
+	shards := s.Shards()
+	for _, g := range s.groupByShard(keys) {
+		m := shards[g.index]
+		m.RLock()
+		for _, i := range g.positions {
+			values[i], exists[i] = m.lookup(keys[i])
+		}
+		m.RUnlock()
+	}
+	return values, exists
+}
+
+// SetMany sets all entries, takes each shard lock once.
+func (c *CMap) SetMany(entries map[string]interface{}) {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	keys := make([]string, 0, len(entries))
+	for key, _ := range entries {
+		keys = append(keys, key)
+	}
+
+	shards := s.Shards()
+	for _, g := range s.groupByShard(keys) {
+		m := shards[g.index]
+		m.Lock()
+		for _, i := range g.positions {
+			c.setValue(m, keys[i], entries[keys[i]])
+		}
+		c.unlock(m)
+	}
+}
@@ -0,0 +1,194 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func BenchmarkCmapGetMany(b *testing.B) {
+	c := New(WithSlabSize(32))
+	keys := make([]string, 200)
+	for i := 0; i < len(keys); i += 1 {
+		keys[i] = strconv.Itoa(i)
+		c.Set(keys[i], i)
+	}
+	writer := func(done chan struct{}) {
+		for i := 0; ; i += 1 {
+			select {
+			case <-done:
+				return
+			default:
+				c.Set(keys[i%len(keys)], i)
+			}
+		}
+	}
+
+	b.Run("Get", func(tb *testing.B) {
+		done := make(chan struct{})
+		defer close(done)
+		go writer(done)
+
+		tb.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				for _, key := range keys {
+					c.Get(key)
+				}
+			}
+		})
+	})
+	b.Run("GetMany", func(tb *testing.B) {
+		done := make(chan struct{})
+		defer close(done)
+		go writer(done)
+
+		tb.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				c.GetMany(keys)
+			}
+		})
+	})
+}
+
+func TestCmapClear(t *testing.T) {
+	r := newTestEvictRecorder()
+	c := New(WithSlabSize(8), WithOnEvict(r.OnEvict))
+	c.Clear()
+
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+	c.Clear()
+
+	if c.Len() != 0 {
+		t.Errorf("all keys cleared: %d", c.Len())
+	}
+	if r.Len() != 100 {
+		t.Errorf("cleared entries evicted: %d", r.Len())
+	}
+	if r.Reason("42") != EvictReasonRemoved {
+		t.Errorf("removed: %s", r.Reason("42"))
+	}
+
+	c.SetWithTTL("expired", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	c.Clear()
+	for _, m := range c.loadSlab().Shards() {
+		if n := len(m.Cache.(*defaultCache).values); n != 0 {
+			t.Errorf("expired entry removed from shard: %d", n)
+		}
+	}
+	if r.Reason("expired") != EvictReasonExpired {
+		t.Errorf("expired: %s", r.Reason("expired"))
+	}
+}
+
+func TestCmapRemoveAll(t *testing.T) {
+	c := New(WithSlabSize(8))
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+
+	if n := c.RemoveAll(nil); n != 0 {
+		t.Errorf("no keys: %d", n)
+	}
+
+	keys := []string{"1", "2", "3", "notfound", "3"}
+	if n := c.RemoveAll(keys); n != 3 {
+		t.Errorf("3 keys removed: %d", n)
+	}
+	if c.Len() != 97 {
+		t.Errorf("97 keys remains: %d", c.Len())
+	}
+	for _, key := range keys {
+		if _, ok := c.Get(key); ok {
+			t.Errorf("%s removed", key)
+		}
+	}
+}
+
+func TestCmapRemoveByPrefix(t *testing.T) {
+	c := New(WithSlabSize(8))
+	for i := 0; i < 50; i += 1 {
+		c.Set("user/"+strconv.Itoa(i), i)
+		c.Set("session/"+strconv.Itoa(i), i)
+	}
+
+	if n := c.RemoveByPrefix("user/"); n != 50 {
+		t.Errorf("50 keys removed: %d", n)
+	}
+	if n := c.RemoveByPrefix("user/"); n != 0 {
+		t.Errorf("already removed: %d", n)
+	}
+	if c.Len() != 50 {
+		t.Errorf("session keys remains: %d", c.Len())
+	}
+	if _, ok := c.Get("session/1"); ok != true {
+		t.Errorf("session/1 exists")
+	}
+}
+
+func TestCmapRemoveWhere(t *testing.T) {
+	c := New(WithSlabSize(8))
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+
+	n := c.RemoveWhere(func(key string, value interface{}) bool {
+		return value.(int)%2 == 0
+	})
+	if n != 50 {
+		t.Errorf("50 even keys removed: %d", n)
+	}
+	c.Range(func(key string, value interface{}) bool {
+		if value.(int)%2 == 0 {
+			t.Errorf("%s removed", key)
+		}
+		return true
+	})
+}
+
+func TestCmapGetMany(t *testing.T) {
+	c := New(WithSlabSize(8))
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+
+	keys := []string{"10", "notfound", "2", "99", "10"}
+	values, exists := c.GetMany(keys)
+	if len(values) != len(keys) || len(exists) != len(keys) {
+		t.Fatalf("results in input order")
+	}
+	expect := []interface{}{10, nil, 2, 99, 10}
+	for i := range keys {
+		if values[i] != expect[i] {
+			t.Errorf("[%d] %s = %v actual %v", i, keys[i], expect[i], values[i])
+		}
+		if exists[i] != (expect[i] != nil) {
+			t.Errorf("[%d] %s exists", i, keys[i])
+		}
+	}
+
+	values, exists = c.GetMany(nil)
+	if len(values) != 0 || len(exists) != 0 {
+		t.Errorf("empty")
+	}
+}
+
+func TestCmapSetMany(t *testing.T) {
+	c := New(WithSlabSize(8))
+	c.Set("1", "old")
+
+	entries := make(map[string]interface{})
+	for i := 0; i < 100; i += 1 {
+		entries[strconv.Itoa(i)] = i
+	}
+	c.SetMany(entries)
+
+	if c.Len() != 100 {
+		t.Errorf("100 keys set: %d", c.Len())
+	}
+	if v, _ := c.Get("1"); v.(int) != 1 {
+		t.Errorf("overwrite old value: %v", v)
+	}
+}
@@ -2,6 +2,7 @@ package cmap
 
 import (
 	"sync"
+	"time"
 )
 
 type Cache interface {
@@ -12,27 +13,52 @@ type Cache interface {
 	RUnlock()
 
 	Set(string, interface{})
+	SetWithTTL(string, interface{}, time.Duration)
 	Get(string) (interface{}, bool)
 	Remove(string) (interface{}, bool)
+	RemoveExpired() int
 
+	// Len returns number of stored entries in O(1), expired entries are counted until removed
 	Len() int
 	Keys() []string
+
+	// SetOnEvict sets func that receives entries leaving the cache, every overwrite is reported even by the same value
+	SetOnEvict(OnEvictFunc)
+}
+
+type CacheFactory func(capacity int) Cache
+
+// rangeCache is implemented by Cache that iterates entries without copying keys
+type rangeCache interface {
+	Range(RangeFunc)
 }
 
 // compile check
 var (
-	_ Cache = (*defaultCache)(nil)
+	_ Cache       = (*defaultCache)(nil)
+	_ rangeCache  = (*defaultCache)(nil)
+	_ expiryCache = (*defaultCache)(nil)
 )
 
 type defaultCache struct {
-	mutex  *sync.RWMutex
-	values map[string]interface{}
+	mutex   *sync.RWMutex
+	values  map[string]interface{}
+	expires map[string]int64
+	onEvict OnEvictFunc
 }
 
 func (c *defaultCache) Lock() {
 	c.mutex.Lock()
 }
 
+func (c *defaultCache) TryLock() bool {
+	return c.mutex.TryLock()
+}
+
+func (c *defaultCache) TryRLock() bool {
+	return c.mutex.TryRLock()
+}
+
 func (c *defaultCache) RLock() {
 	c.mutex.RLock()
 }
@@ -45,19 +71,103 @@ func (c *defaultCache) RUnlock() {
 	c.mutex.RUnlock()
 }
 
+func (c *defaultCache) SetOnEvict(fn OnEvictFunc) {
+	c.onEvict = fn
+}
+
+func (c *defaultCache) evictReplaced(key string, value interface{}) {
+	if c.onEvict == nil {
+		return
+	}
+	old, ok := c.values[key]
+	if ok != true {
+		return
+	}
+	if c.isExpired(key, time.Now().UnixNano()) {
+		c.onEvict(key, old, EvictReasonExpired)
+		return
+	}
+	c.onEvict(key, old, EvictReasonReplaced)
+}
+
 func (c *defaultCache) Set(key string, value interface{}) {
+	c.evictReplaced(key, value)
 	c.values[key] = value
+	if 0 < len(c.expires) {
+		delete(c.expires, key)
+	}
+}
+
+func (c *defaultCache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		c.Set(key, value)
+		return
+	}
+	c.evictReplaced(key, value)
+	c.values[key] = value
+	c.expires[key] = time.Now().Add(ttl).UnixNano()
 }
 
 func (c *defaultCache) Get(key string) (interface{}, bool) {
 	v, ok := c.values[key]
+	if ok && c.isExpired(key, time.Now().UnixNano()) {
+		return nil, false
+	}
 	return v, ok
 }
 
 func (c *defaultCache) Remove(key string) (interface{}, bool) {
 	v, ok := c.values[key]
+	if ok != true {
+		return nil, false
+	}
+	expired := c.isExpired(key, time.Now().UnixNano())
 	delete(c.values, key)
-	return v, ok
+	delete(c.expires, key)
+
+	if expired {
+		if c.onEvict != nil {
+			c.onEvict(key, v, EvictReasonExpired)
+		}
+		return nil, false
+	}
+	if c.onEvict != nil {
+		c.onEvict(key, v, EvictReasonRemoved)
+	}
+	return v, true
+}
+
+func (c *defaultCache) RemoveExpired() int {
+	now := time.Now().UnixNano()
+	removed := 0
+	for k, e := range c.expires {
+		if e <= now {
+			v := c.values[k]
+			delete(c.values, k)
+			delete(c.expires, k)
+			removed += 1
+			if c.onEvict != nil {
+				c.onEvict(k, v, EvictReasonExpired)
+			}
+		}
+	}
+	return removed
+}
+
+func (c *defaultCache) TTL(key string) (time.Duration, bool) {
+	e, ok := c.expires[key]
+	if ok != true {
+		return 0, false
+	}
+	return time.Duration(e - time.Now().UnixNano()), true
+}
+
+func (c *defaultCache) isExpired(key string, now int64) bool {
+	if len(c.expires) < 1 {
+		return false
+	}
+	e, ok := c.expires[key]
+	return ok && e <= now
 }
 
 func (c *defaultCache) Len() int {
@@ -65,16 +175,45 @@ func (c *defaultCache) Len() int {
 }
 
 func (c *defaultCache) Keys() []string {
+	now := time.Now().UnixNano()
 	keys := make([]string, 0, len(c.values))
 	for k, _ := range c.values {
+		if c.isExpired(k, now) {
+			continue
+		}
 		keys = append(keys, k)
 	}
 	return keys
 }
 
+func (c *defaultCache) Range(fn RangeFunc) {
+	now := time.Now().UnixNano()
+	for k, v := range c.values {
+		if c.isExpired(k, now) {
+			continue
+		}
+		if fn(k, v) != true {
+			return
+		}
+	}
+}
+
+func (c *defaultCache) rangeExpiry(fn func(key string, value interface{}, expireAt int64) bool) {
+	for k, v := range c.values {
+		if fn(k, v, c.expires[k]) != true {
+			return
+		}
+	}
+}
+
+func NewDefaultCache(capacity int) Cache {
+	return newDefaultCache(capacity)
+}
+
 func newDefaultCache(size int) *defaultCache {
 	return &defaultCache{
-		mutex:  new(sync.RWMutex),
-		values: make(map[string]interface{}, size),
+		mutex:   new(sync.RWMutex),
+		values:  make(map[string]interface{}, size),
+		expires: make(map[string]int64),
 	}
 }
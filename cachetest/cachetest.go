@@ -0,0 +1,346 @@
+// Package cachetest provides conformance tests for cmap.Cache implementations.
+//
+//	func TestMyCache(t *testing.T) {
+//		cachetest.Run(t, func(capacity int) cmap.Cache {
+//			return NewMyCache(capacity)
+//		})
+//	}
+package cachetest
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/octu0/cmap"
+)
+
+func Run(t *testing.T, factory cmap.CacheFactory) {
+	t.Run("LockUnlock", func(tt *testing.T) {
+		TestLockUnlock(tt, factory)
+	})
+	t.Run("RLockRUnlock", func(tt *testing.T) {
+		TestRLockRUnlock(tt, factory)
+	})
+	t.Run("SetGetRemove", func(tt *testing.T) {
+		TestSetGetRemove(tt, factory)
+	})
+	t.Run("Len", func(tt *testing.T) {
+		TestLen(tt, factory)
+	})
+	t.Run("Keys", func(tt *testing.T) {
+		TestKeys(tt, factory)
+	})
+	t.Run("Range", func(tt *testing.T) {
+		TestRange(tt, factory)
+	})
+	t.Run("TTL", func(tt *testing.T) {
+		TestTTL(tt, factory)
+	})
+	t.Run("OnEvict", func(tt *testing.T) {
+		TestOnEvict(tt, factory)
+	})
+}
+
+type latch struct {
+	ch chan struct{}
+}
+
+func (l *latch) Release() {
+	close(l.ch)
+}
+
+func (l *latch) Wait() {
+	<-l.ch
+}
+
+func newLatch() *latch {
+	return &latch{make(chan struct{}, 0)}
+}
+
+func lockBlockTime(lock, unlock func(), blockLock, blockUnlock func(), blockTime time.Duration) time.Duration {
+	d := make(chan time.Duration)
+	startup := newLatch()
+	blockLatch := newLatch()
+	go func() {
+		startup.Wait()
+
+		lock()
+		blockLatch.Release()
+
+		// blocking time
+		time.Sleep(blockTime)
+
+		unlock()
+	}()
+	go func() {
+		blockLatch.Wait()
+
+		e := time.Now()
+		blockLock()
+		blockUnlock()
+		d <- time.Since(e)
+	}()
+
+	startup.Release()
+	return <-d
+}
+
+func TestLockUnlock(t *testing.T, factory cmap.CacheFactory) {
+	c := factory(16)
+
+	for _, blockTime := range []time.Duration{30 * time.Millisecond, 100 * time.Millisecond} {
+		dur := lockBlockTime(c.Lock, c.Unlock, c.Lock, c.Unlock, blockTime)
+		if dur < blockTime {
+			t.Errorf("no block %s", dur)
+		}
+	}
+
+	blockTime := 150 * time.Millisecond
+	nop := func() {}
+	if dur := lockBlockTime(nop, nop, c.Lock, c.Unlock, blockTime); blockTime <= dur {
+		t.Errorf("blocked without lock %s", dur)
+	}
+}
+
+func TestRLockRUnlock(t *testing.T, factory cmap.CacheFactory) {
+	c := factory(16)
+	blockTime := 50 * time.Millisecond
+
+	if dur := lockBlockTime(c.Lock, c.Unlock, c.RLock, c.RUnlock, blockTime); dur < blockTime {
+		t.Errorf("lock+blockR must block %s", dur)
+	}
+	if dur := lockBlockTime(c.RLock, c.RUnlock, c.Lock, c.Unlock, blockTime); dur < blockTime {
+		t.Errorf("lockR+block must block %s", dur)
+	}
+	if dur := lockBlockTime(c.RLock, c.RUnlock, c.RLock, c.RUnlock, blockTime); blockTime <= dur {
+		t.Errorf("lockR+blockR must no block %s", dur)
+	}
+}
+
+func TestSetGetRemove(t *testing.T, factory cmap.CacheFactory) {
+	c := factory(0)
+
+	if _, ok := c.Get("foo"); ok {
+		t.Errorf("foo not set")
+	}
+	if _, ok := c.Remove("foo"); ok {
+		t.Errorf("foo not exists")
+	}
+
+	c.Set("foo", "bar")
+	if v, ok := c.Get("foo"); ok != true {
+		t.Errorf("key foo not exists")
+	} else {
+		if s, ok := v.(string); ok != true || s != "bar" {
+			t.Errorf("value is bar: %v", v)
+		}
+	}
+
+	c.Set("12345", 456)
+	if v, ok := c.Get("12345"); ok != true {
+		t.Errorf("key 12345 not exists")
+	} else {
+		if i, ok := v.(int); ok != true || i != 456 {
+			t.Errorf("value is 456: %v", v)
+		}
+	}
+
+	c.Set("foo", "baz")
+	if v, _ := c.Get("foo"); v != "baz" {
+		t.Errorf("value updated baz: %v", v)
+	}
+
+	if old, ok := c.Remove("foo"); ok != true {
+		t.Errorf("foo exists")
+	} else {
+		if s, ok := old.(string); ok != true || s != "baz" {
+			t.Errorf("old value is baz: %v", old)
+		}
+	}
+	if _, ok := c.Remove("foo"); ok {
+		t.Errorf("foo already removed")
+	}
+	if _, ok := c.Get("foo"); ok {
+		t.Errorf("foo already removed")
+	}
+}
+
+func TestLen(t *testing.T, factory cmap.CacheFactory) {
+	for _, capacity := range []int{16, 0} {
+		c := factory(capacity)
+		if c.Len() != 0 {
+			t.Errorf("capacity != len")
+		}
+
+		size := 100
+		for i := 0; i < size; i += 1 {
+			c.Set(strconv.Itoa(i), i)
+		}
+		if c.Len() != size {
+			t.Errorf("%d keys setup: %d", size, c.Len())
+		}
+
+		for i := 0; i < size; i += 1 {
+			c.Remove(strconv.Itoa(i))
+		}
+		if c.Len() != 0 {
+			t.Errorf("all keys removed: %d", c.Len())
+		}
+	}
+}
+
+func TestKeys(t *testing.T, factory cmap.CacheFactory) {
+	c := factory(8)
+	if ks := c.Keys(); 0 < len(ks) {
+		t.Errorf("no key")
+	}
+
+	keys := []string{"foo1", "foo2", "foo3"}
+	for _, k := range keys {
+		c.Set(k, k)
+	}
+
+	ks := c.Keys()
+	sort.Strings(ks)
+	if len(ks) != len(keys) {
+		t.Fatalf("all keys: %v", ks)
+	}
+	for i := range keys {
+		if ks[i] != keys[i] {
+			t.Errorf("key[%d] = %s actual = %s", i, keys[i], ks[i])
+		}
+	}
+}
+
+// Ranger is optionally implemented by Cache, CMap uses Keys and Get otherwise
+type Ranger interface {
+	Range(cmap.RangeFunc)
+}
+
+func TestRange(t *testing.T, factory cmap.CacheFactory) {
+	cache := factory(8)
+	c, ok := cache.(Ranger)
+	if ok != true {
+		t.Skip("Range not implemented")
+	}
+	c.Range(func(key string, value interface{}) bool {
+		t.Errorf("no entry")
+		return true
+	})
+
+	for i := 0; i < 10; i += 1 {
+		cache.Set(strconv.Itoa(i), i)
+	}
+	cache.SetWithTTL("expired", -1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	visited := make(map[string]int)
+	c.Range(func(key string, value interface{}) bool {
+		visited[key] = value.(int)
+		return true
+	})
+	if len(visited) != 10 {
+		t.Errorf("visit all 10 entries: %v", visited)
+	}
+	for k, v := range visited {
+		if k != strconv.Itoa(v) {
+			t.Errorf("key %s value %d", k, v)
+		}
+	}
+
+	count := 0
+	c.Range(func(key string, value interface{}) bool {
+		count += 1
+		return count < 3
+	})
+	if count != 3 {
+		t.Errorf("stop at 3: %d", count)
+	}
+}
+
+func TestTTL(t *testing.T, factory cmap.CacheFactory) {
+	c := factory(0)
+	c.SetWithTTL("foo", "bar", 30*time.Millisecond)
+	c.SetWithTTL("noexpire", "value", 0)
+	c.Set("hello", "world")
+
+	if v, ok := c.Get("foo"); ok != true || v != "bar" {
+		t.Errorf("foo not expired: %v", v)
+	}
+	if c.Len() != 3 {
+		t.Errorf("3 keys set: %d", c.Len())
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	if _, ok := c.Get("foo"); ok {
+		t.Errorf("foo expired")
+	}
+	if c.Len() != 3 {
+		t.Errorf("expired key counted until removed: %d", c.Len())
+	}
+	if ks := c.Keys(); len(ks) != 2 {
+		t.Errorf("expired key not listed: %v", ks)
+	} else {
+		sort.Strings(ks)
+		if ks[0] != "hello" || ks[1] != "noexpire" {
+			t.Errorf("expired key not listed: %v", ks)
+		}
+	}
+	if removed := c.RemoveExpired(); removed != 1 {
+		t.Errorf("1 key expired: %d", removed)
+	}
+	if c.Len() != 2 {
+		t.Errorf("expired key removed: %d", c.Len())
+	}
+	if removed := c.RemoveExpired(); removed != 0 {
+		t.Errorf("already removed: %d", removed)
+	}
+
+	c.SetWithTTL("foo", "bar", 10*time.Millisecond)
+	c.Set("foo", "baz")
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("foo"); ok != true {
+		t.Errorf("Set clears ttl")
+	}
+
+	c.SetWithTTL("foo", "bar", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Remove("foo"); ok {
+		t.Errorf("expired value not returned")
+	}
+}
+
+func TestOnEvict(t *testing.T, factory cmap.CacheFactory) {
+	c := factory(0)
+	evicted := make(map[string]cmap.EvictReason)
+	c.SetOnEvict(func(key string, value interface{}, reason cmap.EvictReason) {
+		evicted[key] = reason
+	})
+
+	c.Set("removed", 1)
+	c.Remove("removed")
+	c.Set("replaced", 1)
+	c.Set("replaced", 2)
+	c.Set("same", 1)
+	c.Set("same", 1)
+	c.SetWithTTL("expired", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	c.RemoveExpired()
+
+	expect := map[string]cmap.EvictReason{
+		"removed":  cmap.EvictReasonRemoved,
+		"replaced": cmap.EvictReasonReplaced,
+		"same":     cmap.EvictReasonReplaced,
+		"expired":  cmap.EvictReasonExpired,
+	}
+	if len(evicted) != len(expect) {
+		t.Errorf("%d keys evicted: %v", len(expect), evicted)
+	}
+	for key, reason := range expect {
+		if evicted[key] != reason {
+			t.Errorf("%s %s actual %s", key, reason, evicted[key])
+		}
+	}
+}
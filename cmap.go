@@ -1,5 +1,11 @@
 package cmap
 
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 type GetFunc func(exists bool, value interface{}) (processedValue interface{})
 
 type UpsertFunc func(exists bool, oldValue interface{}) (newValue interface{})
@@ -8,56 +14,178 @@ type SetIfFunc func(exists bool, value interface{}) (newValue interface{}, isSet
 
 type RemoveIfFunc func(exists bool, value interface{}) bool
 
+type RangeFunc func(key string, value interface{}) (next bool)
+
 type CMap struct {
-	s *slab
+	current   atomic.Value
+	opt       *cmapOption
+	gate      *resizeGate
+	statsBase ShardStats
+	ttl       time.Duration
+	onEvict   OnEvictFunc
+	loads     *loadGroup
+	watch     *watchHub
+	wal       *writeAheadLog
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        *sync.WaitGroup
 }
 
 func New(funcs ...cmapOptionFunc) *CMap {
+	c := newCMap(funcs)
+	c.start()
+	return c
+}
+
+func newCMap(funcs []cmapOptionFunc) *CMap {
 	opt := newDefaultOption()
 	for _, fn := range funcs {
 		fn(opt)
 	}
-	return &CMap{
-		s: newSlab(opt),
+	watch := newWatchHub(opt.watchBufferSize, opt.watchDropPolicy)
+	c := &CMap{
+		opt:     opt,
+		gate:    newResizeGate(),
+		ttl:     opt.defaultTTL,
+		onEvict: opt.onEvict,
+		loads:   newLoadGroup(opt.loadErrorTTL),
+		watch:   watch,
+		done:    make(chan struct{}),
+		wg:      new(sync.WaitGroup),
 	}
+	c.current.Store(newSlabWithHooks(opt, watch, nil))
+	return c
 }
 
-func (c *CMap) Set(key string, value interface{}) {
-	m := c.s.GetShard(key)
-	m.Lock()
-	defer m.Unlock()
+// start runs background sweeper and auto resize
+func (c *CMap) start() {
+	if 0 < c.opt.sweepInterval {
+		c.wg.Add(1)
+		go c.runSweeper(c.opt.sweepInterval)
+	}
+	if 0 < c.opt.autoResizeInterval && 0 < c.opt.autoResizeMaxShardLen {
+		c.wg.Add(1)
+		go c.runAutoResize(c.opt.autoResizeInterval, c.opt.autoResizeMaxShardLen)
+	}
+}
+
+func (c *CMap) Name() string {
+	return c.opt.name
+}
+
+func (c *CMap) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	c.wg.Wait()
+	if c.wal != nil {
+		return c.wal.close()
+	}
+	return nil
+}
+
+func (c *CMap) runSweeper(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.RemoveExpired()
+		}
+	}
+}
+
+func (c *CMap) RemoveExpired() int {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	removed := 0
+	for _, m := range s.Shards() {
+		m.Lock()
+		removed += m.RemoveExpired()
+		c.unlock(m)
+	}
+	return removed
+}
+
+// unlock releases the shard lock, then notifies entries evicted while it was held
+func (c *CMap) unlock(m *shard) {
+	c.release(m)
+	if b := m.budget; b != nil && b.over() {
+		c.evictOverBudget(b, m)
+	}
+}
+
+// release unlocks shard, then notifies evicted entries and error of write-ahead log
+func (c *CMap) release(m *shard) {
+	evicted := m.takeEvicted()
+	w := m.wal
+	m.Unlock()
+
+	for _, e := range evicted {
+		c.onEvict(e.key, e.value, e.reason)
+	}
+	if w != nil {
+		w.reportError()
+	}
+}
 
+func (c *CMap) setValue(m Cache, key string, value interface{}) {
+	if 0 < c.ttl {
+		m.SetWithTTL(key, value, c.ttl)
+		return
+	}
 	m.Set(key, value)
 }
 
+func (c *CMap) Set(key string, value interface{}) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	c.setValue(m, key, value)
+}
+
+func (c *CMap) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	m.SetWithTTL(key, value, ttl)
+}
+
 func (c *CMap) Get(key string) (interface{}, bool) {
-	m := c.s.GetShard(key)
-	m.RLock()
+	m := c.rlockShard(key)
 	defer m.RUnlock()
 
-	return m.Get(key)
+	return m.lookup(key)
 }
 
 func (c *CMap) GetRLocked(key string, fn GetFunc) interface{} {
-	m := c.s.GetShard(key)
-	m.RLock()
+	m := c.rlockShard(key)
 	defer m.RUnlock()
 
-	v, ok := m.Get(key)
+	v, ok := m.lookup(key)
 	return fn(ok, v)
 }
 
 func (c *CMap) Remove(key string) (interface{}, bool) {
-	m := c.s.GetShard(key)
-	m.Lock()
-	defer m.Unlock()
+	m := c.lockShard(key)
+	defer c.unlock(m)
 
 	return m.Remove(key)
 }
 
+// Len returns number of entries, expired entries are counted until the sweeper or RemoveExpired removes them.
 func (c *CMap) Len() int {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
 	count := 0
-	for _, m := range c.s.Shards() {
+	for _, m := range s.Shards() {
 		m.RLock()
 		count += m.Len()
 		m.RUnlock()
@@ -66,7 +194,10 @@ func (c *CMap) Len() int {
 }
 
 func (c *CMap) Keys() []string {
-	shards := c.s.Shards()
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	shards := s.Shards()
 	keys := make([]string, 0, len(shards))
 	for _, m := range shards {
 		m.RLock()
@@ -76,45 +207,120 @@ func (c *CMap) Keys() []string {
 	return keys
 }
 
+// Range calls fn for each entry while holding read lock of the shard, stops when fn returns false.
+// fn must not modify the CMap, use RangeCopy instead.
+func (c *CMap) Range(fn RangeFunc) {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	next := true
+	for _, m := range s.Shards() {
+		m.RLock()
+		m.Range(func(key string, value interface{}) bool {
+			next = fn(key, value)
+			return next
+		})
+		m.RUnlock()
+
+		if next != true {
+			return
+		}
+	}
+}
+
+// RangeCopy calls fn for each entry of copied shard without holding lock, so fn can access the CMap.
+func (c *CMap) RangeCopy(fn RangeFunc) {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	keys := make([]string, 0, 64)
+	values := make([]interface{}, 0, 64)
+	for _, m := range s.Shards() {
+		keys, values = keys[:0], values[:0]
+
+		m.RLock()
+		m.Range(func(key string, value interface{}) bool {
+			keys = append(keys, key)
+			values = append(values, value)
+			return true
+		})
+		m.RUnlock()
+
+		for i := range keys {
+			if fn(keys[i], values[i]) != true {
+				return
+			}
+		}
+	}
+}
+
+// Snapshot returns a copy of all entries at a single point in time.
+// all shards are read locked in index order while copying.
+func (c *CMap) Snapshot() map[string]interface{} {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	shards := s.Shards()
+	for _, m := range shards {
+		m.RLock()
+	}
+	defer func() {
+		for _, m := range shards {
+			m.RUnlock()
+		}
+	}()
+
+	size := 0
+	for _, m := range shards {
+		size += m.Len()
+	}
+	snapshot := make(map[string]interface{}, size)
+	for _, m := range shards {
+		m.Range(func(key string, value interface{}) bool {
+			snapshot[key] = value
+			return true
+		})
+	}
+	return snapshot
+}
+
 func (c *CMap) Upsert(key string, fn UpsertFunc) (newValue interface{}) {
-	m := c.s.GetShard(key)
-	m.Lock()
-	defer m.Unlock()
+	m := c.lockShard(key)
+	defer c.unlock(m)
 
 	oldValue, ok := m.Get(key)
 	newValue = fn(ok, oldValue)
-	m.Set(key, newValue)
+	c.setValue(m, key, newValue)
 	return
 }
 
 func (c *CMap) SetIfAbsent(key string, value interface{}) (updated bool) {
-	m := c.s.GetShard(key)
-	m.Lock()
-	defer m.Unlock()
+	m := c.lockShard(key)
+	defer c.unlock(m)
 
 	if _, ok := m.Get(key); ok != true {
-		m.Set(key, value)
+		c.setValue(m, key, value)
 		return true
 	}
 	return false
 }
 
-func (c *CMap) SetIf(key string, fn SetIfFunc) {
-	m := c.s.GetShard(key)
-	m.Lock()
-	defer m.Unlock()
+func (c *CMap) SetIf(key string, fn SetIfFunc) (updated bool) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
 
 	v, ok := m.Get(key)
 	setValue, isSet := fn(ok, v)
 	if isSet {
-		m.Set(key, setValue)
+		c.setValue(m, key, setValue)
+		return true
 	}
+	return false
 }
 
 func (c *CMap) RemoveIf(key string, fn RemoveIfFunc) (removed bool) {
-	m := c.s.GetShard(key)
-	m.Lock()
-	defer m.Unlock()
+	m := c.lockShard(key)
+	defer c.unlock(m)
 
 	v, ok := m.Get(key)
 	remove := fn(ok, v)
@@ -124,3 +330,50 @@ func (c *CMap) RemoveIf(key string, fn RemoveIfFunc) (removed bool) {
 	}
 	return false
 }
+
+// CompareAndSwap swaps the value of key if it exists and is equal to old.
+// old must be of comparable type, same as sync.Map.
+func (c *CMap) CompareAndSwap(key string, old, new interface{}) (swapped bool) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	v, ok := m.Get(key)
+	if ok && v == old {
+		c.setValue(m, key, new)
+		return true
+	}
+	return false
+}
+
+// CompareAndDelete deletes key if its value is equal to old.
+func (c *CMap) CompareAndDelete(key string, old interface{}) (deleted bool) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	v, ok := m.Get(key)
+	if ok && v == old {
+		m.Remove(key)
+		return true
+	}
+	return false
+}
+
+func (c *CMap) Swap(key string, value interface{}) (previous interface{}, loaded bool) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	previous, loaded = m.Get(key)
+	c.setValue(m, key, value)
+	return
+}
+
+func (c *CMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	if v, ok := m.Get(key); ok {
+		return v, true
+	}
+	c.setValue(m, key, value)
+	return value, false
+}
@@ -277,6 +277,33 @@ func BenchmarkCompare(b *testing.B) {
 	})
 }
 
+func BenchmarkShardSelect(b *testing.B) {
+	hashes := make([]uint64, 1024)
+	for i := 0; i < len(hashes); i += 1 {
+		hashes[i] = NewFNV64HashFun().Hash64(strconv.Itoa(rand.Int()))
+	}
+
+	opt := newDefaultOption()
+	opt.slabSize = 1000
+	s := newSlab(opt)
+	size := uint64(opt.slabSize)
+
+	b.Run("modulo", func(tb *testing.B) {
+		idx := 0
+		for i := 0; i < tb.N; i += 1 {
+			idx += int(hashes[i&1023] % size)
+		}
+		_ = idx
+	})
+	b.Run("mask", func(tb *testing.B) {
+		idx := 0
+		for i := 0; i < tb.N; i += 1 {
+			idx += int(mixShard(hashes[i&1023]) & s.mask)
+		}
+		_ = idx
+	})
+}
+
 func TestCmapSetGetRemove(t *testing.T) {
 	c := New()
 	if _, ok := c.Get("foobar"); ok {
@@ -486,12 +513,15 @@ func TestCmapSetIf(t *testing.T) {
 	t.Run("notexists/noset", func(tt *testing.T) {
 		c := New()
 
-		c.SetIf("foo", func(exists bool, oldValue interface{}) (interface{}, bool) {
+		updated := c.SetIf("foo", func(exists bool, oldValue interface{}) (interface{}, bool) {
 			if exists {
 				tt.Errorf("key foo must not exists")
 			}
 			return "noset-testdata", false
 		})
+		if updated {
+			tt.Errorf("not updated")
+		}
 		if _, ok := c.Get("foo"); ok {
 			tt.Errorf("must no set foo")
 		}
@@ -499,12 +529,15 @@ func TestCmapSetIf(t *testing.T) {
 	t.Run("notexists/set", func(tt *testing.T) {
 		c := New()
 
-		c.SetIf("foo", func(exists bool, oldValue interface{}) (interface{}, bool) {
+		updated := c.SetIf("foo", func(exists bool, oldValue interface{}) (interface{}, bool) {
 			if exists {
 				tt.Errorf("key foo must not exists")
 			}
 			return "new-value", true
 		})
+		if updated != true {
+			tt.Errorf("updated")
+		}
 		if v, ok := c.Get("foo"); ok != true {
 			tt.Errorf("must foo exists")
 		} else {
@@ -625,3 +658,400 @@ func TestCmapRemoveIf(t *testing.T) {
 		}
 	})
 }
+
+func TestCmapTTL(t *testing.T) {
+	t.Run("SetWithTTL", func(tt *testing.T) {
+		c := New()
+		defer c.Close()
+
+		c.SetWithTTL("foo", "bar", 50*time.Millisecond)
+		if _, ok := c.Get("foo"); ok != true {
+			tt.Errorf("foo not expired")
+		}
+		time.Sleep(60 * time.Millisecond)
+
+		if _, ok := c.Get("foo"); ok {
+			tt.Errorf("foo expired")
+		}
+		if ok := c.GetRLocked("foo", func(ok bool, v interface{}) interface{} {
+			return ok
+		}); ok.(bool) {
+			tt.Errorf("foo expired")
+		}
+		if c.SetIfAbsent("foo", "baz") != true {
+			tt.Errorf("expired key is absent")
+		}
+	})
+	t.Run("WithDefaultTTL", func(tt *testing.T) {
+		c := New(WithDefaultTTL(50 * time.Millisecond))
+		defer c.Close()
+
+		c.Set("foo", "bar")
+		c.Upsert("hello", func(exists bool, v interface{}) interface{} {
+			return "world"
+		})
+		c.SetWithTTL("quux", "no expire", 0)
+		if c.Len() != 3 {
+			tt.Errorf("3 keys set")
+		}
+		time.Sleep(60 * time.Millisecond)
+
+		if c.Len() != 3 {
+			tt.Errorf("expired keys counted until removed: %d", c.Len())
+		}
+		if _, ok := c.Get("quux"); ok != true {
+			tt.Errorf("quux no expire")
+		}
+		if removed := c.RemoveExpired(); removed != 2 {
+			tt.Errorf("2 keys removed: %d", removed)
+		}
+		if c.Len() != 1 {
+			tt.Errorf("2 keys expired: %d", c.Len())
+		}
+	})
+	t.Run("WithSweepInterval", func(tt *testing.T) {
+		c := New(WithSlabSize(4), WithSweepInterval(10*time.Millisecond))
+		for i := 0; i < 100; i += 1 {
+			key := strconv.Itoa(i)
+			c.SetWithTTL(key, key, 10*time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		for _, m := range c.loadSlab().Shards() {
+			m.Lock()
+			if n := m.RemoveExpired(); n != 0 {
+				tt.Errorf("sweeper removes expired keys: %d remains", n)
+			}
+			m.Unlock()
+		}
+		if err := c.Close(); err != nil {
+			tt.Errorf("no error: %+v", err)
+		}
+		if err := c.Close(); err != nil {
+			tt.Errorf("close twice: %+v", err)
+		}
+	})
+}
+
+func TestCmapMaxEntries(t *testing.T) {
+	t.Run("WithMaxEntries", func(tt *testing.T) {
+		c := New(WithSlabSize(4), WithMaxEntries(100))
+		for i := 0; i < 1000; i += 1 {
+			key := strconv.Itoa(i)
+			c.Set(key, key)
+		}
+		if 100 < c.Len() {
+			tt.Errorf("bounded 100 entries: %d", c.Len())
+		}
+		if _, ok := c.Get("999"); ok != true {
+			tt.Errorf("recently set key exists")
+		}
+	})
+	t.Run("default slab size", func(tt *testing.T) {
+		c := New(WithMaxEntries(100))
+		for i := 0; i < 1000; i += 1 {
+			key := strconv.Itoa(i)
+			c.Set(key, key)
+		}
+		if 100 < c.Len() {
+			tt.Errorf("bounded 100 entries: %d", c.Len())
+		}
+		if c.SlabSize() != 8 {
+			tt.Errorf("slab size reduced: %d", c.SlabSize())
+		}
+	})
+	t.Run("WithMaxEntriesPerShard", func(tt *testing.T) {
+		c := New(WithSlabSize(4), WithMaxEntriesPerShard(10))
+		for i := 0; i < 1000; i += 1 {
+			key := strconv.Itoa(i)
+			c.Set(key, key)
+		}
+		if c.Len() != 40 {
+			tt.Errorf("bounded 10 entries x 4 shards: %d", c.Len())
+		}
+	})
+}
+
+func TestCmapMaxBytes(t *testing.T) {
+	sizer := func(key string, value interface{}) int64 {
+		return int64(len(value.([]byte)))
+	}
+	t.Run("default slab size", func(tt *testing.T) {
+		c := New(WithMaxBytes(1<<20), WithSizer(sizer))
+		for i := 0; i < 1000; i += 1 {
+			c.Set(strconv.Itoa(i), make([]byte, 10*1024))
+			if _, ok := c.Get(strconv.Itoa(i)); ok != true {
+				tt.Fatalf("10KiB value fits in 1MiB: %d", i)
+			}
+		}
+		s := c.Stats()
+		if 1<<20 < s.Bytes {
+			tt.Errorf("total within budget: %d", s.Bytes)
+		}
+		if s.Len < 90 {
+			tt.Errorf("budget is shared by shards: %d", s.Len)
+		}
+		if _, ok := c.Get("999"); ok != true {
+			tt.Errorf("recently set key exists")
+		}
+	})
+	t.Run("larger than budget", func(tt *testing.T) {
+		evicted := make([]string, 0)
+		c := New(WithMaxBytes(1024), WithSizer(sizer), WithOnEvict(func(key string, value interface{}, reason EvictReason) {
+			evicted = append(evicted, key)
+		}))
+		c.Set("foo", make([]byte, 100))
+		c.Set("bar", make([]byte, 2048))
+		if _, ok := c.Get("bar"); ok {
+			tt.Errorf("never fits")
+		}
+		if _, ok := c.Get("foo"); ok != true {
+			tt.Errorf("others are kept")
+		}
+		if len(evicted) != 1 || evicted[0] != "bar" {
+			tt.Errorf("evicted: %v", evicted)
+		}
+	})
+	t.Run("resize", func(tt *testing.T) {
+		c := New(WithSlabSize(2), WithMaxBytes(1000), WithSizer(sizer))
+		for i := 0; i < 10; i += 1 {
+			c.Set(strconv.Itoa(i), make([]byte, 100))
+		}
+		c.Resize(16)
+		for i := 10; i < 20; i += 1 {
+			c.Set(strconv.Itoa(i), make([]byte, 100))
+		}
+		if s := c.Stats(); 1000 < s.Bytes || s.Len != 10 {
+			tt.Errorf("budget kept across resize: %d %d", s.Bytes, s.Len)
+		}
+	})
+}
+
+type testCountingCache struct {
+	Cache
+	sets int
+}
+
+func (c *testCountingCache) Set(key string, value interface{}) {
+	c.sets += 1
+	c.Cache.Set(key, value)
+}
+
+func TestCmapCacheFactory(t *testing.T) {
+	caches := make([]*testCountingCache, 0)
+	c := New(WithSlabSize(4), WithMaxEntries(1), WithCacheFactory(func(capacity int) Cache {
+		cc := &testCountingCache{Cache: NewDefaultCache(capacity)}
+		caches = append(caches, cc)
+		return cc
+	}))
+	if len(caches) != 4 {
+		t.Errorf("factory called per shard: %d", len(caches))
+	}
+
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+	if c.Len() != 100 {
+		t.Errorf("factory takes precedence over max entries: %d", c.Len())
+	}
+
+	visited := 0
+	c.Range(func(key string, value interface{}) bool {
+		visited += 1
+		return true
+	})
+	if visited != 100 {
+		t.Errorf("range by keys without Range of cache: %d", visited)
+	}
+
+	sets := 0
+	for _, cc := range caches {
+		sets += cc.sets
+	}
+	if sets != 100 {
+		t.Errorf("custom cache used: %d", sets)
+	}
+}
+
+func TestCmapRange(t *testing.T) {
+	c := New(WithSlabSize(8))
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+
+	t.Run("all", func(tt *testing.T) {
+		sum := 0
+		c.Range(func(key string, value interface{}) bool {
+			sum += value.(int)
+			return true
+		})
+		if sum != 4950 {
+			tt.Errorf("visit all entries: %d", sum)
+		}
+	})
+	t.Run("stop", func(tt *testing.T) {
+		count := 0
+		c.Range(func(key string, value interface{}) bool {
+			count += 1
+			return count < 10
+		})
+		if count != 10 {
+			tt.Errorf("stop at 10: %d", count)
+		}
+	})
+	t.Run("copy", func(tt *testing.T) {
+		count := 0
+		c.RangeCopy(func(key string, value interface{}) bool {
+			// access CMap in callback
+			c.Set(key, value.(int)*2)
+			c.Remove("copy/" + key)
+			count += 1
+			return true
+		})
+		if count != 100 {
+			tt.Errorf("visit all entries: %d", count)
+		}
+		if v, _ := c.Get("50"); v.(int) != 100 {
+			tt.Errorf("updated in callback: %v", v)
+		}
+
+		count = 0
+		c.RangeCopy(func(key string, value interface{}) bool {
+			count += 1
+			return count < 5
+		})
+		if count != 5 {
+			tt.Errorf("stop at 5: %d", count)
+		}
+	})
+}
+
+func TestCmapSnapshot(t *testing.T) {
+	c := New(WithSlabSize(8))
+	if s := c.Snapshot(); len(s) != 0 {
+		t.Errorf("empty snapshot")
+	}
+
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+	s := c.Snapshot()
+	if len(s) != 100 {
+		t.Errorf("100 entries: %d", len(s))
+	}
+	if s["42"].(int) != 42 {
+		t.Errorf("value copied")
+	}
+
+	c.Set("42", -1)
+	c.Remove("43")
+	if s["42"].(int) != 42 {
+		t.Errorf("snapshot is not affected by later update")
+	}
+	if _, ok := s["43"]; ok != true {
+		t.Errorf("snapshot is not affected by later remove")
+	}
+
+	t.Run("consistent", func(tt *testing.T) {
+		// two keys in different shards are always updated together
+		c := New(WithSlabSize(2))
+		c.Set("a", 0)
+		c.Set("b", 0)
+
+		// lock in index order
+		ma, mb := c.loadSlab().Shards()[0], c.loadSlab().Shards()[1]
+		if ma != c.loadSlab().GetShard("a") {
+			ma, mb = mb, ma
+		}
+		if ma == mb {
+			tt.Fatalf("a and b in different shards")
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 1; i <= 1000; i += 1 {
+				c.loadSlab().Shards()[0].Lock()
+				c.loadSlab().Shards()[1].Lock()
+				ma.Set("a", i)
+				mb.Set("b", i)
+				c.loadSlab().Shards()[1].Unlock()
+				c.loadSlab().Shards()[0].Unlock()
+			}
+		}()
+		for i := 0; i < 100; i += 1 {
+			s := c.Snapshot()
+			if s["a"] != s["b"] {
+				tt.Errorf("inconsistent snapshot a=%v b=%v", s["a"], s["b"])
+			}
+		}
+		<-done
+	})
+}
+
+func TestCmapCompareAndSwap(t *testing.T) {
+	c := New()
+	if c.CompareAndSwap("foo", nil, "bar") {
+		t.Errorf("key foo not exists")
+	}
+	if _, ok := c.Get("foo"); ok {
+		t.Errorf("not stored")
+	}
+
+	c.Set("foo", "bar")
+	if c.CompareAndSwap("foo", "baz", "qux") {
+		t.Errorf("old value is bar")
+	}
+	if c.CompareAndSwap("foo", "bar", "qux") != true {
+		t.Errorf("swapped")
+	}
+	if v, _ := c.Get("foo"); v.(string) != "qux" {
+		t.Errorf("new value is qux: %v", v)
+	}
+}
+
+func TestCmapCompareAndDelete(t *testing.T) {
+	c := New()
+	if c.CompareAndDelete("foo", nil) {
+		t.Errorf("key foo not exists")
+	}
+
+	c.Set("foo", 1)
+	if c.CompareAndDelete("foo", 2) {
+		t.Errorf("value is 1")
+	}
+	if c.CompareAndDelete("foo", int64(1)) {
+		t.Errorf("different type")
+	}
+	if c.CompareAndDelete("foo", 1) != true {
+		t.Errorf("deleted")
+	}
+	if _, ok := c.Get("foo"); ok {
+		t.Errorf("foo deleted")
+	}
+}
+
+func TestCmapSwap(t *testing.T) {
+	c := New()
+	if prev, loaded := c.Swap("foo", "bar"); loaded || prev != nil {
+		t.Errorf("no previous value: %v", prev)
+	}
+	if prev, loaded := c.Swap("foo", "baz"); loaded != true || prev.(string) != "bar" {
+		t.Errorf("previous value is bar: %v", prev)
+	}
+	if v, _ := c.Get("foo"); v.(string) != "baz" {
+		t.Errorf("swapped baz: %v", v)
+	}
+}
+
+func TestCmapLoadOrStore(t *testing.T) {
+	c := New()
+	if actual, loaded := c.LoadOrStore("foo", "bar"); loaded || actual.(string) != "bar" {
+		t.Errorf("stored bar: %v", actual)
+	}
+	if actual, loaded := c.LoadOrStore("foo", "baz"); loaded != true || actual.(string) != "bar" {
+		t.Errorf("loaded bar: %v", actual)
+	}
+	if v, _ := c.Get("foo"); v.(string) != "bar" {
+		t.Errorf("not updated: %v", v)
+	}
+}
@@ -0,0 +1,262 @@
+// Package cmapmetrics exports cmap.CMap statistics to expvar and Prometheus.
+//
+// Collector has the same shape as prometheus.Collector without depending on it,
+// adapting to prometheus only needs to convert Desc and Metric:
+//
+//	func (a adapter) Collect(ch chan<- prometheus.Metric) {
+//		a.c.Collect(func(m cmapmetrics.Metric) {
+//			ch <- prometheus.MustNewConstMetric(descs[m.Name], valueType(m.Type), m.Value, m.LabelValues()...)
+//		})
+//	}
+package cmapmetrics
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/octu0/cmap"
+)
+
+var (
+	ErrNoName        = errors.New("cmapmetrics: map has no name, use cmap.WithName")
+	ErrDuplicateName = errors.New("cmapmetrics: map name already registered")
+)
+
+type MetricType uint8
+
+const (
+	CounterMetric MetricType = iota + 1
+	GaugeMetric
+)
+
+func (t MetricType) String() string {
+	switch t {
+	case CounterMetric:
+		return "counter"
+	case GaugeMetric:
+		return "gauge"
+	}
+	return "untyped"
+}
+
+type Desc struct {
+	Name   string
+	Help   string
+	Type   MetricType
+	Labels []string
+}
+
+type Metric struct {
+	Name   string
+	Type   MetricType
+	Labels map[string]string
+	Value  float64
+}
+
+func (m Metric) LabelValues() []string {
+	d := descs[m.Name]
+	values := make([]string, len(d.Labels))
+	for i, l := range d.Labels {
+		values[i] = m.Labels[l]
+	}
+	return values
+}
+
+const (
+	labelMap = "map"
+	labelOp  = "op"
+)
+
+var (
+	descList = []Desc{
+		{"cmap_entries", "Number of entries in the map.", GaugeMetric, []string{labelMap}},
+		{"cmap_bytes", "Approximate size of entries measured by sizer.", GaugeMetric, []string{labelMap}},
+		{"cmap_shards", "Number of shards in the map.", GaugeMetric, []string{labelMap}},
+		{"cmap_operations_total", "Number of operations by type.", CounterMetric, []string{labelMap, labelOp}},
+		{"cmap_hits_total", "Number of lookups that found the key.", CounterMetric, []string{labelMap}},
+		{"cmap_misses_total", "Number of lookups that did not find the key.", CounterMetric, []string{labelMap}},
+		{"cmap_evictions_total", "Number of entries evicted by expiry or capacity.", CounterMetric, []string{labelMap}},
+		{"cmap_lock_contentions_total", "Number of shard lock acquisitions that waited.", CounterMetric, []string{labelMap}},
+		{"cmap_lock_wait_seconds_total", "Total time waited for shard locks.", CounterMetric, []string{labelMap}},
+	}
+	descs = func() map[string]Desc {
+		m := make(map[string]Desc, len(descList))
+		for _, d := range descList {
+			m[d.Name] = d
+		}
+		return m
+	}()
+)
+
+// Collector collects statistics of registered maps
+type Collector struct {
+	mutex *sync.RWMutex
+	maps  map[string]*cmap.CMap
+}
+
+func (c *Collector) Register(m *cmap.CMap) error {
+	name := m.Name()
+	if name == "" {
+		return ErrNoName
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.maps[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateName, name)
+	}
+	c.maps[name] = m
+	return nil
+}
+
+func (c *Collector) Unregister(m *cmap.CMap) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.maps[m.Name()] == m {
+		delete(c.maps, m.Name())
+	}
+}
+
+func (c *Collector) Describe(fn func(Desc)) {
+	for _, d := range descList {
+		fn(d)
+	}
+}
+
+func (c *Collector) Collect(fn func(Metric)) {
+	for _, name := range c.names() {
+		c.mutex.RLock()
+		m, ok := c.maps[name]
+		c.mutex.RUnlock()
+		if ok != true {
+			continue
+		}
+		collectStats(name, m.Stats(), fn)
+	}
+}
+
+func (c *Collector) names() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	names := make([]string, 0, len(c.maps))
+	for name, _ := range c.maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func collectStats(name string, s cmap.Stats, fn func(Metric)) {
+	labels := map[string]string{labelMap: name}
+	metric := func(metricName string, value float64) {
+		fn(Metric{metricName, descs[metricName].Type, labels, value})
+	}
+	op := func(opName string, value uint64) {
+		fn(Metric{"cmap_operations_total", CounterMetric, map[string]string{labelMap: name, labelOp: opName}, float64(value)})
+	}
+
+	metric("cmap_entries", float64(s.Len))
+	metric("cmap_bytes", float64(s.Bytes))
+	metric("cmap_shards", float64(len(s.Shards)))
+	op("get", s.Hits+s.Misses)
+	op("set", s.Sets)
+	op("remove", s.Removes)
+	metric("cmap_hits_total", float64(s.Hits))
+	metric("cmap_misses_total", float64(s.Misses))
+	metric("cmap_evictions_total", float64(s.Evictions))
+	metric("cmap_lock_contentions_total", float64(s.Contentions))
+	metric("cmap_lock_wait_seconds_total", s.LockWait.Seconds())
+}
+
+// WriteText writes metrics in Prometheus text exposition format
+func (c *Collector) WriteText(w io.Writer) error {
+	metrics := make(map[string][]Metric, len(descList))
+	c.Collect(func(m Metric) {
+		metrics[m.Name] = append(metrics[m.Name], m)
+	})
+
+	for _, d := range descList {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.Name, d.Help, d.Name, d.Type); err != nil {
+			return err
+		}
+		for _, m := range metrics[d.Name] {
+			pairs := make([]string, len(d.Labels))
+			for i, l := range d.Labels {
+				pairs[i] = fmt.Sprintf("%s=%q", l, m.Labels[l])
+			}
+			if _, err := fmt.Fprintf(w, "%s{%s} %v\n", d.Name, strings.Join(pairs, ","), m.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExpvarFunc returns expvar.Func that reports cmap.Stats summary of each map
+func (c *Collector) ExpvarFunc() expvar.Func {
+	return func() interface{} {
+		values := make(map[string]map[string]interface{})
+		for _, name := range c.names() {
+			c.mutex.RLock()
+			m, ok := c.maps[name]
+			c.mutex.RUnlock()
+			if ok != true {
+				continue
+			}
+			s := m.Stats()
+			values[name] = map[string]interface{}{
+				"entries":          s.Len,
+				"bytes":            s.Bytes,
+				"shards":           len(s.Shards),
+				"gets":             s.Hits + s.Misses,
+				"sets":             s.Sets,
+				"removes":          s.Removes,
+				"hits":             s.Hits,
+				"misses":           s.Misses,
+				"evictions":        s.Evictions,
+				"lock_contentions": s.Contentions,
+				"lock_wait_ns":     s.LockWait.Nanoseconds(),
+				"max_shard_len":    s.MaxShardLen,
+				"min_shard_len":    s.MinShardLen,
+				"stddev_shard_len": s.StddevShardLen,
+			}
+		}
+		return values
+	}
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		mutex: new(sync.RWMutex),
+		maps:  make(map[string]*cmap.CMap),
+	}
+}
+
+var (
+	defaultCollector = NewCollector()
+	publishOnce      sync.Once
+)
+
+func DefaultCollector() *Collector {
+	return defaultCollector
+}
+
+// Register registers map to DefaultCollector, and publishes it as expvar "cmap"
+func Register(m *cmap.CMap) error {
+	publishOnce.Do(func() {
+		expvar.Publish("cmap", defaultCollector.ExpvarFunc())
+	})
+	return defaultCollector.Register(m)
+}
+
+func Unregister(m *cmap.CMap) {
+	defaultCollector.Unregister(m)
+}
@@ -0,0 +1,124 @@
+package cmapmetrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/octu0/cmap"
+)
+
+func TestCollectorRegister(t *testing.T) {
+	t.Run("noname", func(tt *testing.T) {
+		c := NewCollector()
+		if err := c.Register(cmap.New()); errors.Is(err, ErrNoName) != true {
+			tt.Errorf("map without name can not register: %v", err)
+		}
+	})
+	t.Run("duplicate", func(tt *testing.T) {
+		c := NewCollector()
+		if err := c.Register(cmap.New(cmap.WithName("foo"))); err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		if err := c.Register(cmap.New(cmap.WithName("foo"))); errors.Is(err, ErrDuplicateName) != true {
+			tt.Errorf("same name can not register: %v", err)
+		}
+	})
+	t.Run("unregister", func(tt *testing.T) {
+		c := NewCollector()
+		m := cmap.New(cmap.WithName("foo"))
+		c.Register(m)
+		c.Unregister(m)
+		count := 0
+		c.Collect(func(Metric) {
+			count += 1
+		})
+		if count != 0 {
+			tt.Errorf("no metrics after unregister: %d", count)
+		}
+	})
+}
+
+func TestCollectorCollect(t *testing.T) {
+	m := cmap.New(cmap.WithName("foo"), cmap.WithSlabSize(4))
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Get("a")
+	m.Get("c")
+	m.Remove("b")
+
+	c := NewCollector()
+	c.Register(m)
+
+	values := make(map[string]float64)
+	c.Collect(func(metric Metric) {
+		if metric.Labels["map"] != "foo" {
+			t.Errorf("labelled by map name: %v", metric.Labels)
+		}
+		name := metric.Name
+		if op, ok := metric.Labels["op"]; ok {
+			name += "/" + op
+		}
+		values[name] = metric.Value
+	})
+
+	expect := map[string]float64{
+		"cmap_entries":                 1,
+		"cmap_bytes":                   0,
+		"cmap_shards":                  4,
+		"cmap_operations_total/get":    2,
+		"cmap_operations_total/set":    2,
+		"cmap_operations_total/remove": 1,
+		"cmap_hits_total":              1,
+		"cmap_misses_total":            1,
+		"cmap_evictions_total":         0,
+	}
+	for name, v := range expect {
+		if values[name] != v {
+			t.Errorf("%s = %v: %v", name, v, values[name])
+		}
+	}
+
+	labels := Metric{Name: "cmap_operations_total", Labels: map[string]string{"map": "foo", "op": "set"}}.LabelValues()
+	if len(labels) != 2 || labels[0] != "foo" || labels[1] != "set" {
+		t.Errorf("label values ordered by desc: %v", labels)
+	}
+}
+
+func TestCollectorWriteText(t *testing.T) {
+	m := cmap.New(cmap.WithName("foo"))
+	m.Set("a", 1)
+
+	c := NewCollector()
+	c.Register(m)
+
+	buf := bytes.NewBuffer(nil)
+	if err := c.WriteText(buf); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	text := buf.String()
+	for _, line := range []string{
+		"# TYPE cmap_entries gauge",
+		`cmap_entries{map="foo"} 1`,
+		`cmap_operations_total{map="foo",op="set"} 1`,
+	} {
+		if strings.Contains(text, line) != true {
+			t.Errorf("contains %q: %s", line, text)
+		}
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	m := cmap.New(cmap.WithName("expvar"))
+	m.Set("a", 1)
+	if err := Register(m); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	defer Unregister(m)
+
+	values := DefaultCollector().ExpvarFunc()().(map[string]map[string]interface{})
+	if values["expvar"]["entries"] != 1 {
+		t.Errorf("entries = 1: %v", values["expvar"])
+	}
+}
@@ -0,0 +1,315 @@
+package cmap
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCodecUnsupportedType = errors.New("cmap: codec unsupported type")
+	ErrCodecTypeRegistered  = errors.New("cmap: codec type already registered")
+	ErrCodecInvalidData     = errors.New("cmap: codec invalid data")
+)
+
+// Codec converts values to bytes and back, used to persist interface{} values
+type Codec interface {
+	Name() string
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// CodecRegistry maps names to concrete types, so that codecs can restore the type of interface{} value
+type CodecRegistry struct {
+	mutex  *sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}
+
+func (r *CodecRegistry) Register(name string, value interface{}) error {
+	return r.register(name, value, nil)
+}
+
+// register adds type after prepare succeeds, so that failure of prepare does not leave the type registered
+func (r *CodecRegistry) register(name string, value interface{}, prepare func() error) error {
+	t := reflect.TypeOf(value)
+	if t == nil {
+		return fmt.Errorf("%w: nil", ErrCodecUnsupportedType)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.byName[name]; ok {
+		return fmt.Errorf("%w: %s", ErrCodecTypeRegistered, name)
+	}
+	if _, ok := r.byType[t]; ok {
+		return fmt.Errorf("%w: %s", ErrCodecTypeRegistered, t)
+	}
+	if prepare != nil {
+		if err := prepare(); err != nil {
+			return err
+		}
+	}
+	r.byName[name] = t
+	r.byType[t] = name
+	return nil
+}
+
+func (r *CodecRegistry) typeName(value interface{}) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	name, ok := r.byType[reflect.TypeOf(value)]
+	return name, ok
+}
+
+func (r *CodecRegistry) typeOf(name string) (reflect.Type, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	t, ok := r.byName[name]
+	return t, ok
+}
+
+func NewCodecRegistry() *CodecRegistry {
+	r := &CodecRegistry{
+		mutex:  new(sync.RWMutex),
+		byName: make(map[string]reflect.Type),
+		byType: make(map[reflect.Type]string),
+	}
+	for name, value := range map[string]interface{}{
+		"string":   "",
+		"bool":     false,
+		"int":      int(0),
+		"int8":     int8(0),
+		"int16":    int16(0),
+		"int32":    int32(0),
+		"int64":    int64(0),
+		"uint":     uint(0),
+		"uint8":    uint8(0),
+		"uint16":   uint16(0),
+		"uint32":   uint32(0),
+		"uint64":   uint64(0),
+		"float32":  float32(0),
+		"float64":  float64(0),
+		"bytes":    []byte(nil),
+		"time":     time.Time{},
+		"duration": time.Duration(0),
+	} {
+		r.Register(name, value)
+	}
+	return r
+}
+
+var defaultCodecRegistry = NewCodecRegistry()
+
+// RegisterCodecType registers custom type to the registry used by built-in codecs (and gob).
+// it returns error instead of panic when gob has registered the type or name differently.
+func RegisterCodecType(name string, value interface{}) error {
+	return defaultCodecRegistry.register(name, value, func() error {
+		return registerGob(name, value)
+	})
+}
+
+func registerGob(name string, value interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: gob: %v", ErrCodecTypeRegistered, r)
+		}
+	}()
+	gob.RegisterName(name, value)
+	return nil
+}
+
+// gobCodec encodes values as gob interface value, custom types must be registered by RegisterCodecType or gob.Register
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(value interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(&value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func NewGobCodec() Codec {
+	return gobCodec{}
+}
+
+type jsonEnvelope struct {
+	Type  string          `json:"t,omitempty"`
+	Value json.RawMessage `json:"v,omitempty"`
+}
+
+// jsonCodec encodes values as JSON with registered type name, so numbers and structs keep their types
+type jsonCodec struct {
+	registry *CodecRegistry
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (c jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	if value == nil {
+		return json.Marshal(jsonEnvelope{})
+	}
+	name, ok := c.registry.typeName(value)
+	if ok != true {
+		return nil, fmt.Errorf("%w: %T", ErrCodecUnsupportedType, value)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonEnvelope{name, data})
+}
+
+func (c jsonCodec) Unmarshal(data []byte) (interface{}, error) {
+	e := jsonEnvelope{}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	if e.Type == "" {
+		return nil, nil
+	}
+	t, ok := c.registry.typeOf(e.Type)
+	if ok != true {
+		return nil, fmt.Errorf("%w: %s", ErrCodecUnsupportedType, e.Type)
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(e.Value, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+func NewJSONCodec() Codec {
+	return NewJSONCodecWithRegistry(defaultCodecRegistry)
+}
+
+func NewJSONCodecWithRegistry(registry *CodecRegistry) Codec {
+	return jsonCodec{registry}
+}
+
+const (
+	rawTagBytes byte = iota + 1
+	rawTagString
+	rawTagBinary
+)
+
+// rawCodec encodes []byte, string and registered encoding.BinaryMarshaler as length-prefixed bytes.
+//
+//	tag (1 byte) | [type name length | type name] (binary only) | data length | data
+type rawCodec struct {
+	registry *CodecRegistry
+}
+
+func (rawCodec) Name() string {
+	return "raw"
+}
+
+func (c rawCodec) Marshal(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return appendRaw([]byte{rawTagBytes}, v), nil
+	case string:
+		return appendRaw([]byte{rawTagString}, []byte(v)), nil
+	case encoding.BinaryMarshaler:
+		name, ok := c.registry.typeName(value)
+		if ok != true {
+			return nil, fmt.Errorf("%w: %T", ErrCodecUnsupportedType, value)
+		}
+		data, err := v.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return appendRaw(appendRaw([]byte{rawTagBinary}, []byte(name)), data), nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrCodecUnsupportedType, value)
+}
+
+func (c rawCodec) Unmarshal(data []byte) (interface{}, error) {
+	if len(data) < 1 {
+		return nil, ErrCodecInvalidData
+	}
+	tag, data := data[0], data[1:]
+	switch tag {
+	case rawTagBytes:
+		b, _, err := readRaw(data)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case rawTagString:
+		b, _, err := readRaw(data)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case rawTagBinary:
+		name, data, err := readRaw(data)
+		if err != nil {
+			return nil, err
+		}
+		t, ok := c.registry.typeOf(string(name))
+		if ok != true {
+			return nil, fmt.Errorf("%w: %s", ErrCodecUnsupportedType, name)
+		}
+		b, _, err := readRaw(data)
+		if err != nil {
+			return nil, err
+		}
+		v := reflect.New(t)
+		u, ok := v.Interface().(encoding.BinaryUnmarshaler)
+		if ok != true {
+			return nil, fmt.Errorf("%w: %s", ErrCodecUnsupportedType, name)
+		}
+		if err := u.UnmarshalBinary(b); err != nil {
+			return nil, err
+		}
+		return v.Elem().Interface(), nil
+	}
+	return nil, ErrCodecInvalidData
+}
+
+func appendRaw(b []byte, data []byte) []byte {
+	b = appendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func readRaw(b []byte) ([]byte, []byte, error) {
+	size, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < size {
+		return nil, nil, ErrCodecInvalidData
+	}
+	return b[n : n+int(size)], b[n+int(size):], nil
+}
+
+func NewRawCodec() Codec {
+	return NewRawCodecWithRegistry(defaultCodecRegistry)
+}
+
+func NewRawCodecWithRegistry(registry *CodecRegistry) Codec {
+	return rawCodec{registry}
+}
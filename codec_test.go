@@ -0,0 +1,158 @@
+package cmap
+
+import (
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type codecTestValue struct {
+	Name  string
+	Count int
+}
+
+type codecTestBinary struct {
+	n int
+}
+
+func (b codecTestBinary) MarshalBinary() ([]byte, error) {
+	return []byte(strconv.Itoa(b.n)), nil
+}
+
+func (b *codecTestBinary) UnmarshalBinary(data []byte) error {
+	n, err := strconv.Atoi(string(data))
+	b.n = n
+	return err
+}
+
+func init() {
+	if err := RegisterCodecType("cmap.codecTestValue", codecTestValue{}); err != nil {
+		panic(err)
+	}
+	if err := RegisterCodecType("cmap.codecTestBinary", codecTestBinary{}); err != nil {
+		panic(err)
+	}
+}
+
+func testCodecRoundTrip(t *testing.T, codec Codec, values []interface{}) {
+	for _, v := range values {
+		data, err := codec.Marshal(v)
+		if err != nil {
+			t.Fatalf("%T no error: %+v", v, err)
+		}
+		d, err := codec.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%T no error: %+v", v, err)
+		}
+		if reflect.DeepEqual(d, v) != true {
+			t.Errorf("round trip %T(%v): %T(%v)", v, v, d, d)
+		}
+	}
+}
+
+func TestGobCodec(t *testing.T) {
+	codec := NewGobCodec()
+	if codec.Name() != "gob" {
+		t.Errorf("name = gob: %s", codec.Name())
+	}
+	testCodecRoundTrip(t, codec, []interface{}{
+		"foo", 123, int64(-1), 1.5, true, []byte("bar"), codecTestValue{"foo", 1},
+	})
+}
+
+func TestJSONCodec(t *testing.T) {
+	codec := NewJSONCodec()
+	if codec.Name() != "json" {
+		t.Errorf("name = json: %s", codec.Name())
+	}
+	testCodecRoundTrip(t, codec, []interface{}{
+		nil, "foo", 123, int8(-1), uint64(1 << 63), float32(1.5), 1.5, true, []byte("bar"),
+		time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC), 3 * time.Second,
+		codecTestValue{"foo", 1},
+	})
+
+	t.Run("unregistered", func(tt *testing.T) {
+		type unknown struct{}
+		if _, err := codec.Marshal(unknown{}); errors.Is(err, ErrCodecUnsupportedType) != true {
+			tt.Errorf("unregistered type: %v", err)
+		}
+		if _, err := codec.Unmarshal([]byte(`{"t":"unknown","v":{}}`)); errors.Is(err, ErrCodecUnsupportedType) != true {
+			tt.Errorf("unregistered type name: %v", err)
+		}
+	})
+	t.Run("registry", func(tt *testing.T) {
+		r := NewCodecRegistry()
+		if err := r.Register("custom", codecTestValue{}); err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		if err := r.Register("custom", 1); errors.Is(err, ErrCodecTypeRegistered) != true {
+			tt.Errorf("duplicate name: %v", err)
+		}
+		if err := r.Register("other", codecTestValue{}); errors.Is(err, ErrCodecTypeRegistered) != true {
+			tt.Errorf("duplicate type: %v", err)
+		}
+		testCodecRoundTrip(tt, NewJSONCodecWithRegistry(r), []interface{}{codecTestValue{"bar", 2}})
+		if _, err := NewJSONCodecWithRegistry(r).Marshal(codecTestBinary{}); errors.Is(err, ErrCodecUnsupportedType) != true {
+			tt.Errorf("registered to default registry only: %v", err)
+		}
+	})
+}
+
+func TestRawCodec(t *testing.T) {
+	codec := NewRawCodec()
+	if codec.Name() != "raw" {
+		t.Errorf("name = raw: %s", codec.Name())
+	}
+	testCodecRoundTrip(t, codec, []interface{}{
+		"foo", "", []byte("bar"), codecTestBinary{42}, time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+	})
+
+	if _, err := codec.Marshal(123); errors.Is(err, ErrCodecUnsupportedType) != true {
+		t.Errorf("raw supports bytes only: %v", err)
+	}
+	for _, data := range [][]byte{nil, {0}, {rawTagBytes, 10, 'a'}, {rawTagBinary, 3, 'f', 'o', 'o', 0}} {
+		if _, err := codec.Unmarshal(data); err == nil {
+			t.Errorf("invalid data %v", data)
+		}
+	}
+
+	data, _ := codec.Marshal([]byte("foo"))
+	v, _ := codec.Unmarshal(data)
+	data[len(data)-1] = 'x'
+	if string(v.([]byte)) != "foo" {
+		t.Errorf("decoded bytes not share buffer: %s", v)
+	}
+}
+
+type codecTestGob struct {
+	Name string
+}
+
+func TestRegisterCodecType(t *testing.T) {
+	gob.Register(codecTestGob{})
+
+	if err := RegisterCodecType("cmap.codecTestGob", codecTestGob{}); errors.Is(err, ErrCodecTypeRegistered) != true {
+		t.Errorf("registered to gob by other name: %v", err)
+	}
+	if _, ok := defaultCodecRegistry.typeName(codecTestGob{}); ok {
+		t.Errorf("not registered to registry when gob fails")
+	}
+	if err := RegisterCodecType("cmap.codecTestValue", codecTestValue{}); errors.Is(err, ErrCodecTypeRegistered) != true {
+		t.Errorf("duplicate: %v", err)
+	}
+}
+
+func BenchmarkCodec(b *testing.B) {
+	for _, codec := range []Codec{NewGobCodec(), NewJSONCodec(), NewRawCodec()} {
+		b.Run(codec.Name(), func(tb *testing.B) {
+			value := "hello world"
+			for i := 0; i < tb.N; i += 1 {
+				data, _ := codec.Marshal(value)
+				codec.Unmarshal(data)
+			}
+		})
+	}
+}
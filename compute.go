@@ -0,0 +1,58 @@
+package cmap
+
+type ComputeOp uint8
+
+const (
+	ComputeKeep ComputeOp = iota
+	ComputeStore
+	ComputeDelete
+)
+
+type ComputeFunc func(oldValue interface{}, exists bool) (newValue interface{}, op ComputeOp)
+
+// Compute calls fn while holding the shard lock, then keeps, stores or deletes by returned op.
+// returns the resulting value and whether key exists after fn applied.
+func (c *CMap) Compute(key string, fn ComputeFunc) (value interface{}, exists bool) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	return c.compute(m, key, fn)
+}
+
+// ComputeIfAbsent calls fn only when key does not exist.
+func (c *CMap) ComputeIfAbsent(key string, fn ComputeFunc) (value interface{}, exists bool) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	if v, ok := m.Get(key); ok {
+		return v, true
+	}
+	return c.compute(m, key, fn)
+}
+
+// ComputeIfPresent calls fn only when key exists.
+func (c *CMap) ComputeIfPresent(key string, fn ComputeFunc) (value interface{}, exists bool) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	if _, ok := m.Get(key); ok != true {
+		return nil, false
+	}
+	return c.compute(m, key, fn)
+}
+
+func (c *CMap) compute(m *shard, key string, fn ComputeFunc) (interface{}, bool) {
+	oldValue, ok := m.Get(key)
+	newValue, op := fn(oldValue, ok)
+	switch op {
+	case ComputeStore:
+		c.setValue(m, key, newValue)
+		return newValue, true
+	case ComputeDelete:
+		if ok {
+			m.Remove(key)
+		}
+		return nil, false
+	}
+	return oldValue, ok
+}
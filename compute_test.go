@@ -0,0 +1,126 @@
+package cmap
+
+import (
+	"testing"
+)
+
+func TestCmapCompute(t *testing.T) {
+	t.Run("store", func(tt *testing.T) {
+		c := New()
+		v, ok := c.Compute("counter", func(old interface{}, exists bool) (interface{}, ComputeOp) {
+			if exists {
+				tt.Errorf("counter not exists")
+			}
+			return 1, ComputeStore
+		})
+		if ok != true || v.(int) != 1 {
+			tt.Errorf("stored 1: %v", v)
+		}
+		v, ok = c.Compute("counter", func(old interface{}, exists bool) (interface{}, ComputeOp) {
+			return old.(int) + 1, ComputeStore
+		})
+		if ok != true || v.(int) != 2 {
+			tt.Errorf("stored 2: %v", v)
+		}
+	})
+	t.Run("keep", func(tt *testing.T) {
+		c := New()
+		v, ok := c.Compute("foo", func(old interface{}, exists bool) (interface{}, ComputeOp) {
+			return "ignored", ComputeKeep
+		})
+		if ok || v != nil {
+			tt.Errorf("not exists: %v", v)
+		}
+
+		c.Set("foo", "bar")
+		v, ok = c.Compute("foo", func(old interface{}, exists bool) (interface{}, ComputeOp) {
+			return "ignored", ComputeKeep
+		})
+		if ok != true || v.(string) != "bar" {
+			tt.Errorf("keep bar: %v", v)
+		}
+	})
+	t.Run("delete", func(tt *testing.T) {
+		c := New()
+		c.Set("counter", 1)
+		decr := func(old interface{}, exists bool) (interface{}, ComputeOp) {
+			if n := old.(int) - 1; 0 < n {
+				return n, ComputeStore
+			}
+			return nil, ComputeDelete
+		}
+		v, ok := c.Compute("counter", decr)
+		if ok || v != nil {
+			tt.Errorf("deleted: %v", v)
+		}
+		if _, ok := c.Get("counter"); ok {
+			tt.Errorf("counter deleted")
+		}
+		v, ok = c.Compute("notfound", func(old interface{}, exists bool) (interface{}, ComputeOp) {
+			return nil, ComputeDelete
+		})
+		if ok || v != nil {
+			tt.Errorf("not exists: %v", v)
+		}
+	})
+}
+
+func TestCmapComputeIfAbsent(t *testing.T) {
+	c := New()
+	called := 0
+	fn := func(old interface{}, exists bool) (interface{}, ComputeOp) {
+		called += 1
+		if exists {
+			t.Errorf("called only when absent")
+		}
+		return "bar", ComputeStore
+	}
+
+	if v, ok := c.ComputeIfAbsent("foo", fn); ok != true || v.(string) != "bar" {
+		t.Errorf("stored bar: %v", v)
+	}
+	if v, ok := c.ComputeIfAbsent("foo", fn); ok != true || v.(string) != "bar" {
+		t.Errorf("exists bar: %v", v)
+	}
+	if called != 1 {
+		t.Errorf("called once: %d", called)
+	}
+
+	if v, ok := c.ComputeIfAbsent("noop", func(old interface{}, exists bool) (interface{}, ComputeOp) {
+		return nil, ComputeKeep
+	}); ok || v != nil {
+		t.Errorf("not stored: %v", v)
+	}
+}
+
+func TestCmapComputeIfPresent(t *testing.T) {
+	c := New()
+	called := 0
+	fn := func(old interface{}, exists bool) (interface{}, ComputeOp) {
+		called += 1
+		if exists != true {
+			t.Errorf("called only when present")
+		}
+		return old.(int) * 2, ComputeStore
+	}
+
+	if v, ok := c.ComputeIfPresent("foo", fn); ok || v != nil {
+		t.Errorf("not exists: %v", v)
+	}
+	if called != 0 {
+		t.Errorf("not called: %d", called)
+	}
+
+	c.Set("foo", 2)
+	if v, ok := c.ComputeIfPresent("foo", fn); ok != true || v.(int) != 4 {
+		t.Errorf("stored 4: %v", v)
+	}
+	if v, ok := c.ComputeIfPresent("foo", func(old interface{}, exists bool) (interface{}, ComputeOp) {
+		return nil, ComputeDelete
+	}); ok || v != nil {
+		t.Errorf("deleted: %v", v)
+	}
+	if _, ok := c.Get("foo"); ok {
+		t.Errorf("foo deleted")
+	}
+}
@@ -0,0 +1,133 @@
+package cmap
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+type EvictReason uint8
+
+const (
+	EvictReasonRemoved EvictReason = iota + 1
+	EvictReasonReplaced
+	EvictReasonExpired
+	EvictReasonCapacity
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictReasonRemoved:
+		return "removed"
+	case EvictReasonReplaced:
+		return "replaced"
+	case EvictReasonExpired:
+		return "expired"
+	case EvictReasonCapacity:
+		return "capacity"
+	}
+	return "unknown"
+}
+
+// OnEvictFunc is called when entry leaves the map.
+// CMap calls it outside the shard lock, so it may access the map.
+// Cache implementations report every overwrite as EvictReasonReplaced to the func given by SetOnEvict,
+// CMap skips replacement by the same value, e.g. Upsert that returns the pointer it received.
+type OnEvictFunc func(key string, value interface{}, reason EvictReason)
+
+// oldestEvictor is implemented by Cache that can evict its least recently used entry
+type oldestEvictor interface {
+	EvictOldest() bool
+}
+
+// byteBudget is total size limit of entries shared by shards of a slab.
+// shard evicts entry larger than max by itself, CMap evicts entries of shards in turn while used exceeds max.
+type byteBudget struct {
+	max    int64
+	used   int64
+	cursor uint32
+	shards []*shard
+}
+
+func (b *byteBudget) over() bool {
+	return b.max < atomic.LoadInt64(&b.used)
+}
+
+// evictOverBudget evicts least recently used entry of shards in turn until entries fit in budget.
+// shard written by caller is evicted last, so that entry just set is kept while it fits.
+// shards without entries are skipped without taking the lock.
+func (c *CMap) evictOverBudget(b *byteBudget, written *shard) {
+	size := uint32(len(b.shards))
+	skipped := uint32(0)
+	for b.over() && skipped < size {
+		m := b.shards[atomic.AddUint32(&b.cursor, 1)%size]
+		if m == written || c.evictOldest(m) != true {
+			skipped += 1
+			continue
+		}
+		skipped = 0
+	}
+	for written != nil && b.over() {
+		if c.evictOldest(written) != true {
+			return
+		}
+	}
+}
+
+func (c *CMap) evictOldest(m *shard) bool {
+	bc, ok := m.Cache.(bytesCache)
+	if ok != true || bc.Bytes() < 1 {
+		return false
+	}
+
+	m.Lock()
+	if m.moved {
+		// resized, next slab has its own budget
+		c.release(m)
+		return false
+	}
+	evicted := m.Cache.(oldestEvictor).EvictOldest()
+	c.release(m)
+	return evicted
+}
+
+// reasonCache is implemented by Cache that reports evictions without reading values,
+// shard uses it to count evictions when OnEvict is not set
+type reasonCache interface {
+	SetOnEvictReason(func(EvictReason))
+}
+
+type evictedEntry struct {
+	key    string
+	value  interface{}
+	reason EvictReason
+}
+
+// sameValue reports whether replacing old by value keeps the same value,
+// e.g. Upsert that returns the pointer it received. such replacement is not an eviction.
+func sameValue(old, value interface{}) bool {
+	t := reflect.TypeOf(old)
+	if t != reflect.TypeOf(value) {
+		return false
+	}
+	if t == nil {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return reflect.ValueOf(old).Pointer() == reflect.ValueOf(value).Pointer()
+	}
+	if t.Comparable() != true {
+		return false
+	}
+	return equalInterface(old, value)
+}
+
+// equalInterface compares a and b, interface fields holding uncomparable value are not equal instead of panic
+func equalInterface(a, b interface{}) (equal bool) {
+	defer func() {
+		if recover() != nil {
+			equal = false
+		}
+	}()
+	return a == b
+}
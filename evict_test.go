@@ -0,0 +1,182 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testEvictRecorder struct {
+	mutex   *sync.Mutex
+	evicted map[string]EvictReason
+}
+
+func (r *testEvictRecorder) OnEvict(key string, value interface{}, reason EvictReason) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.evicted[key] = reason
+}
+
+func (r *testEvictRecorder) Reason(key string) EvictReason {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.evicted[key]
+}
+
+func (r *testEvictRecorder) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.evicted)
+}
+
+func newTestEvictRecorder() *testEvictRecorder {
+	return &testEvictRecorder{new(sync.Mutex), make(map[string]EvictReason)}
+}
+
+func TestOnEvict(t *testing.T) {
+	t.Run("removed", func(tt *testing.T) {
+		r := newTestEvictRecorder()
+		c := New(WithOnEvict(r.OnEvict))
+		c.Set("foo", "bar")
+		c.Set("hello", "world")
+
+		c.Remove("foo")
+		c.RemoveIf("hello", func(exists bool, v interface{}) bool {
+			return true
+		})
+		c.Remove("notfound")
+
+		if r.Reason("foo") != EvictReasonRemoved {
+			tt.Errorf("foo removed: %s", r.Reason("foo"))
+		}
+		if r.Reason("hello") != EvictReasonRemoved {
+			tt.Errorf("hello removed: %s", r.Reason("hello"))
+		}
+		if r.Len() != 2 {
+			tt.Errorf("2 keys evicted: %d", r.Len())
+		}
+	})
+	t.Run("replaced", func(tt *testing.T) {
+		r := newTestEvictRecorder()
+		c := New(WithOnEvict(r.OnEvict))
+		c.Set("foo", "bar")
+		c.Set("foo", "baz")
+		if r.Reason("foo") != EvictReasonReplaced {
+			tt.Errorf("foo replaced: %s", r.Reason("foo"))
+		}
+
+		p := &struct{ n int }{}
+		c.Set("ptr", p)
+		c.Upsert("ptr", func(exists bool, v interface{}) interface{} {
+			v.(*struct{ n int }).n += 1
+			return v
+		})
+		buf := []byte("foo")
+		c.Set("buf", buf)
+		c.Set("buf", buf)
+		if r.Len() != 1 {
+			tt.Errorf("same value is not replaced: %v", r.evicted)
+		}
+	})
+	t.Run("expired", func(tt *testing.T) {
+		r := newTestEvictRecorder()
+		c := New(WithOnEvict(r.OnEvict))
+		c.SetWithTTL("foo", "bar", 10*time.Millisecond)
+		c.SetWithTTL("hello", "world", 10*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+
+		c.Set("hello", "world2")
+		c.RemoveExpired()
+		if r.Reason("foo") != EvictReasonExpired {
+			tt.Errorf("foo expired: %s", r.Reason("foo"))
+		}
+		if r.Reason("hello") != EvictReasonExpired {
+			tt.Errorf("hello expired before replace: %s", r.Reason("hello"))
+		}
+	})
+	t.Run("capacity", func(tt *testing.T) {
+		r := newTestEvictRecorder()
+		c := New(WithSlabSize(1), WithMaxEntries(2), WithOnEvict(r.OnEvict))
+		c.Set("a", 1)
+		c.Set("b", 2)
+		c.Set("c", 3)
+		if r.Reason("a") != EvictReasonCapacity {
+			tt.Errorf("a evicted by capacity: %s", r.Reason("a"))
+		}
+		if r.Len() != 1 {
+			tt.Errorf("1 key evicted: %d", r.Len())
+		}
+	})
+	t.Run("replace/uncomparable", func(tt *testing.T) {
+		type S struct {
+			X interface{}
+		}
+		r := newTestEvictRecorder()
+		c := New(WithSlabSize(1), WithOnEvict(r.OnEvict))
+		c.Set("k", S{X: []int{1}})
+		c.Set("k", S{X: []int{1}})
+		if r.Reason("k") != EvictReasonReplaced {
+			tt.Errorf("k replaced: %s", r.Reason("k"))
+		}
+	})
+	t.Run("callback/access", func(tt *testing.T) {
+		var c *CMap
+		c = New(WithSlabSize(1), WithOnEvict(func(key string, value interface{}, reason EvictReason) {
+			// must not deadlock
+			c.Set("evicted/"+key, value)
+		}))
+		for i := 0; i < 10; i += 1 {
+			c.Set(strconv.Itoa(i), i)
+			c.Remove(strconv.Itoa(i))
+		}
+		if c.Len() != 10 {
+			tt.Errorf("evicted keys set: %d", c.Len())
+		}
+	})
+}
+
+func TestEvictReasonString(t *testing.T) {
+	for reason, expect := range map[EvictReason]string{
+		EvictReasonRemoved:  "removed",
+		EvictReasonReplaced: "replaced",
+		EvictReasonExpired:  "expired",
+		EvictReasonCapacity: "capacity",
+		EvictReason(0):      "unknown",
+	} {
+		if reason.String() != expect {
+			t.Errorf("expect %s actual %s", expect, reason.String())
+		}
+	}
+}
+
+func TestSameValue(t *testing.T) {
+	p := new(int)
+	s := []int{1}
+	m := map[string]int{}
+	tests := []struct {
+		a, b   interface{}
+		expect bool
+	}{
+		{nil, nil, true},
+		{"foo", "foo", true},
+		{"foo", "bar", false},
+		{1, int64(1), false},
+		{p, p, true},
+		{p, new(int), false},
+		{s, s, true},
+		{s, []int{1}, false},
+		{m, m, true},
+		{struct{ s []int }{s}, struct{ s []int }{s}, false},
+		{struct{ x interface{} }{s}, struct{ x interface{} }{s}, false},
+		{struct{ x interface{} }{1}, struct{ x interface{} }{1}, true},
+	}
+	for i, tc := range tests {
+		if sameValue(tc.a, tc.b) != tc.expect {
+			t.Errorf("[%d] expect %v", i, tc.expect)
+		}
+	}
+}
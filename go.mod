@@ -1,9 +1,11 @@
 module github.com/octu0/cmap
 
-go 1.16
+go 1.18
 
 require (
 	github.com/cespare/xxhash/v2 v2.2.0
 	github.com/octu0/chanque v1.0.22
 	github.com/orcaman/concurrent-map v0.0.0-20210501183033-44dafcb38ecc
 )
+
+require github.com/rogpeppe/fastuuid v1.2.0 // indirect
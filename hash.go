@@ -1,7 +1,9 @@
 package cmap
 
 import (
+	"encoding/binary"
 	"hash/fnv"
+	"math"
 	"reflect"
 	"unsafe"
 
@@ -38,3 +40,185 @@ func (*fnv64HashFunc) Hash64(key string) uint64 {
 	f.Write(b)
 	return f.Sum64()
 }
+
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// CMapKeyHashFunc extends CMapHashFunc to hash non-string keys directly
+type CMapKeyHashFunc[K comparable] interface {
+	CMapHashFunc
+	HashKey(K) uint64
+}
+
+func NewStringKeyHashFunc(hashFunc CMapHashFunc) CMapKeyHashFunc[string] {
+	return &stringKeyHashFunc{hashFunc}
+}
+
+func NewIntegerKeyHashFunc[K Integer](hashFunc CMapHashFunc) CMapKeyHashFunc[K] {
+	return &integerKeyHashFunc[K]{hashFunc}
+}
+
+func NewUUIDKeyHashFunc(hashFunc CMapHashFunc) CMapKeyHashFunc[[16]byte] {
+	return &uuidKeyHashFunc{hashFunc}
+}
+
+func NewComparableKeyHashFunc[K comparable](hashFunc CMapHashFunc) CMapKeyHashFunc[K] {
+	return &comparableKeyHashFunc[K]{hashFunc}
+}
+
+// keyHashFuncReplacer is implemented by key hash funcs of this package, to apply WithHashFunc
+type keyHashFuncReplacer[K comparable] interface {
+	withHashFunc(CMapHashFunc) CMapKeyHashFunc[K]
+}
+
+type stringKeyHashFunc struct {
+	CMapHashFunc
+}
+
+func (h *stringKeyHashFunc) HashKey(key string) uint64 {
+	return h.Hash64(key)
+}
+
+func (h *stringKeyHashFunc) withHashFunc(hashFunc CMapHashFunc) CMapKeyHashFunc[string] {
+	return NewStringKeyHashFunc(hashFunc)
+}
+
+type integerKeyHashFunc[K Integer] struct {
+	CMapHashFunc
+}
+
+func (*integerKeyHashFunc[K]) HashKey(key K) uint64 {
+	return mix64(uint64(key))
+}
+
+func (h *integerKeyHashFunc[K]) withHashFunc(hashFunc CMapHashFunc) CMapKeyHashFunc[K] {
+	return NewIntegerKeyHashFunc[K](hashFunc)
+}
+
+type uuidKeyHashFunc struct {
+	CMapHashFunc
+}
+
+func (h *uuidKeyHashFunc) HashKey(key [16]byte) uint64 {
+	return h.Hash64(string(key[:]))
+}
+
+func (h *uuidKeyHashFunc) withHashFunc(hashFunc CMapHashFunc) CMapKeyHashFunc[[16]byte] {
+	return NewUUIDKeyHashFunc(hashFunc)
+}
+
+type comparableKeyHashFunc[K comparable] struct {
+	CMapHashFunc
+}
+
+func (h *comparableKeyHashFunc[K]) HashKey(key K) uint64 {
+	return hashComparable(h.CMapHashFunc, key)
+}
+
+func (h *comparableKeyHashFunc[K]) withHashFunc(hashFunc CMapHashFunc) CMapKeyHashFunc[K] {
+	return NewComparableKeyHashFunc[K](hashFunc)
+}
+
+// hashComparable hashes contents of comparable value, values that are == have the same hash.
+// it panics on unhashable value such as slice in interface, same as built-in map.
+func hashComparable(hashFunc CMapHashFunc, key interface{}) uint64 {
+	switch k := key.(type) {
+	case string:
+		return hashFunc.Hash64(k)
+	case int:
+		return mix64(uint64(k))
+	case int64:
+		return mix64(uint64(k))
+	case uint64:
+		return mix64(k)
+	}
+	b := appendComparable(make([]byte, 0, 64), reflect.ValueOf(key))
+	return hashFunc.Hash64(*(*string)(unsafe.Pointer(&b)))
+}
+
+func appendComparable(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return append(b, 0)
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendUint64(b, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint64(b, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return appendFloat(b, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return appendFloat(appendFloat(b, real(c)), imag(c))
+	case reflect.String:
+		b = appendUvarint(b, uint64(v.Len()))
+		return append(b, v.String()...)
+	case reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		return appendUint64(b, uint64(v.Pointer()))
+	case reflect.Array:
+		for i := 0; i < v.Len(); i += 1 {
+			b = appendComparable(b, v.Index(i))
+		}
+		return b
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i += 1 {
+			b = appendComparable(b, v.Field(i))
+		}
+		return b
+	case reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0)
+		}
+		return appendComparable(append(b, 1), v.Elem())
+	}
+	panic("cmap: hash of unhashable type " + v.Type().String())
+}
+
+// appendFloat appends bits of f, +0 and -0 are equal
+func appendFloat(b []byte, f float64) []byte {
+	if f == 0 {
+		f = 0
+	}
+	return appendUint64(b, math.Float64bits(f))
+}
+
+// splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// mixShard spreads hash into upper bits by fibonacci hashing,
+// so that low-quality hashes are evenly selected by the shard bitmask.
+func mixShard(h uint64) uint64 {
+	return (h * 0x9e3779b97f4a7c15) >> 32
+}
+
+// appendUvarint, appendUint32 and appendUint64 append encoded v to b, as binary.Append* of go1.19
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,68 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKeyHashFunc(t *testing.T) {
+	testSpread := func(tt *testing.T, hashes []uint64) {
+		slabSize := uint64(16)
+		counts := make([]int, slabSize)
+		for _, h := range hashes {
+			counts[h%slabSize] += 1
+		}
+		expect := len(hashes) / int(slabSize)
+		for i, c := range counts {
+			if c < expect/2 || expect*2 < c {
+				tt.Errorf("shard[%d] = %d skewed (expect ~%d)", i, c, expect)
+			}
+		}
+	}
+
+	t.Run("string", func(tt *testing.T) {
+		h := NewStringKeyHashFunc(NewXXHashFunc())
+		if h.HashKey("foo") != h.Hash64("foo") {
+			tt.Errorf("string key uses CMapHashFunc")
+		}
+	})
+	t.Run("integer", func(tt *testing.T) {
+		h := NewIntegerKeyHashFunc[uint64](NewXXHashFunc())
+		hashes := make([]uint64, 0, 1600)
+		for i := 0; i < 1600; i += 1 {
+			// sequential ids aligned to the slab size
+			hashes = append(hashes, h.HashKey(uint64(i*16)))
+		}
+		testSpread(tt, hashes)
+
+		if h.HashKey(123) != h.HashKey(123) {
+			tt.Errorf("same key same hash")
+		}
+	})
+	t.Run("uuid", func(tt *testing.T) {
+		h := NewUUIDKeyHashFunc(NewXXHashFunc())
+		hashes := make([]uint64, 0, 1600)
+		for i := 0; i < 1600; i += 1 {
+			id := [16]byte{}
+			copy(id[:], strconv.Itoa(i))
+			hashes = append(hashes, h.HashKey(id))
+		}
+		testSpread(tt, hashes)
+	})
+	t.Run("comparable", func(tt *testing.T) {
+		type compositeKey struct {
+			tenant string
+			id     int
+		}
+		h := NewComparableKeyHashFunc[compositeKey](NewXXHashFunc())
+		hashes := make([]uint64, 0, 1600)
+		for i := 0; i < 1600; i += 1 {
+			hashes = append(hashes, h.HashKey(compositeKey{"foo", i}))
+		}
+		testSpread(tt, hashes)
+
+		if h.HashKey(compositeKey{"bar", 1}) != h.HashKey(compositeKey{"bar", 1}) {
+			tt.Errorf("same key same hash")
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package cmap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLoaderPanic = errors.New("cmap: loader panic")
+
+	// errLoadCanceled tells waiter to load again, loader was stopped by context of the caller that started it
+	errLoadCanceled = errors.New("cmap: load canceled by other caller")
+)
+
+type LoaderFunc func(ctx context.Context) (interface{}, error)
+
+type loadCall struct {
+	done     chan struct{}
+	value    interface{}
+	err      error
+	canceled bool
+}
+
+type loadError struct {
+	err    error
+	expire int64
+}
+
+// loadGroup deduplicates concurrent loads per key
+type loadGroup struct {
+	mutex  *sync.Mutex
+	calls  map[string]*loadCall
+	errs   map[string]loadError
+	errTTL time.Duration
+}
+
+func (g *loadGroup) cachedError(key string) error {
+	e, ok := g.errs[key]
+	if ok != true {
+		return nil
+	}
+	if e.expire <= time.Now().UnixNano() {
+		delete(g.errs, key)
+		return nil
+	}
+	return e.err
+}
+
+func (g *loadGroup) finish(key string, call *loadCall) {
+	g.mutex.Lock()
+	delete(g.calls, key)
+	if call.err != nil && call.canceled != true && 0 < g.errTTL {
+		g.errs[key] = loadError{call.err, time.Now().Add(g.errTTL).UnixNano()}
+	}
+	g.mutex.Unlock()
+
+	close(call.done)
+}
+
+func newLoadGroup(errTTL time.Duration) *loadGroup {
+	return &loadGroup{
+		mutex:  new(sync.Mutex),
+		calls:  make(map[string]*loadCall),
+		errs:   make(map[string]loadError),
+		errTTL: errTTL,
+	}
+}
+
+// GetOrLoad returns value of key, or calls loader on miss and stores its result with default TTL.
+// concurrent calls for the same key wait for a single loader, and its error is not stored
+// unless WithLoadErrorTTL is set. loader runs without holding the shard lock.
+// loader receives ctx of the caller that starts it, when it fails after that ctx is done,
+// waiting callers whose ctx is alive load again instead of returning the error.
+func (c *CMap) GetOrLoad(ctx context.Context, key string, loader LoaderFunc) (interface{}, error) {
+	return c.getOrLoad(ctx, key, c.ttl, loader)
+}
+
+func (c *CMap) GetOrLoadWithTTL(ctx context.Context, key string, ttl time.Duration, loader LoaderFunc) (interface{}, error) {
+	return c.getOrLoad(ctx, key, ttl, loader)
+}
+
+func (c *CMap) getOrLoad(ctx context.Context, key string, ttl time.Duration, loader LoaderFunc) (interface{}, error) {
+	for {
+		if v, ok := c.Get(key); ok {
+			return v, nil
+		}
+
+		g := c.loads
+		g.mutex.Lock()
+		if err := g.cachedError(key); err != nil {
+			g.mutex.Unlock()
+			return nil, err
+		}
+		if call, ok := g.calls[key]; ok {
+			g.mutex.Unlock()
+			v, err := c.waitLoad(ctx, call)
+			if err == errLoadCanceled {
+				continue
+			}
+			return v, err
+		}
+		// loaded while acquiring group lock
+		if v, ok := c.Get(key); ok {
+			g.mutex.Unlock()
+			return v, nil
+		}
+		call := &loadCall{done: make(chan struct{})}
+		g.calls[key] = call
+		g.mutex.Unlock()
+
+		c.doLoad(ctx, key, ttl, loader, call)
+		return call.value, call.err
+	}
+}
+
+func (c *CMap) doLoad(ctx context.Context, key string, ttl time.Duration, loader LoaderFunc, call *loadCall) {
+	call.err = ErrLoaderPanic
+	defer c.loads.finish(key, call)
+
+	value, err := loader(ctx)
+	if err != nil {
+		call.err = err
+		call.canceled = ctx.Err() != nil
+		return
+	}
+	c.SetWithTTL(key, value, ttl)
+	call.value, call.err = value, nil
+}
+
+func (c *CMap) waitLoad(ctx context.Context, call *loadCall) (interface{}, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		if call.canceled && ctx.Err() == nil {
+			return nil, errLoadCanceled
+		}
+		return call.value, call.err
+	}
+}
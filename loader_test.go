@@ -0,0 +1,190 @@
+package cmap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCmapGetOrLoad(t *testing.T) {
+	t.Run("singleflight", func(tt *testing.T) {
+		c := New()
+		calls := int32(0)
+		release := make(chan struct{})
+		loader := func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return "bar", nil
+		}
+
+		wg := new(sync.WaitGroup)
+		for i := 0; i < 10; i += 1 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				v, err := c.GetOrLoad(context.Background(), "foo", loader)
+				if err != nil {
+					tt.Errorf("no error: %+v", err)
+				}
+				if v.(string) != "bar" {
+					tt.Errorf("loaded bar: %v", v)
+				}
+			}()
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			tt.Errorf("loader called once: %d", n)
+		}
+		if v, ok := c.Get("foo"); ok != true || v.(string) != "bar" {
+			tt.Errorf("loaded value cached: %v", v)
+		}
+		if _, err := c.GetOrLoad(context.Background(), "foo", loader); err != nil {
+			tt.Errorf("no error: %+v", err)
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			tt.Errorf("cached value used: %d", n)
+		}
+	})
+	t.Run("error", func(tt *testing.T) {
+		c := New()
+		errLoad := errors.New("load error")
+		calls := 0
+		loader := func(ctx context.Context) (interface{}, error) {
+			calls += 1
+			return nil, errLoad
+		}
+
+		if _, err := c.GetOrLoad(context.Background(), "foo", loader); err != errLoad {
+			tt.Errorf("loader error: %+v", err)
+		}
+		if _, err := c.GetOrLoad(context.Background(), "foo", loader); err != errLoad {
+			tt.Errorf("loader error: %+v", err)
+		}
+		if calls != 2 {
+			tt.Errorf("error not cached: %d", calls)
+		}
+		if _, ok := c.Get("foo"); ok {
+			tt.Errorf("not stored")
+		}
+	})
+	t.Run("WithLoadErrorTTL", func(tt *testing.T) {
+		c := New(WithLoadErrorTTL(30 * time.Millisecond))
+		errLoad := errors.New("load error")
+		calls := 0
+		loader := func(ctx context.Context) (interface{}, error) {
+			calls += 1
+			return nil, errLoad
+		}
+
+		c.GetOrLoad(context.Background(), "foo", loader)
+		if _, err := c.GetOrLoad(context.Background(), "foo", loader); err != errLoad {
+			tt.Errorf("cached error: %+v", err)
+		}
+		if calls != 1 {
+			tt.Errorf("error cached: %d", calls)
+		}
+
+		time.Sleep(40 * time.Millisecond)
+		c.GetOrLoad(context.Background(), "foo", loader)
+		if calls != 2 {
+			tt.Errorf("cached error expired: %d", calls)
+		}
+	})
+	t.Run("GetOrLoadWithTTL", func(tt *testing.T) {
+		c := New()
+		v, err := c.GetOrLoadWithTTL(context.Background(), "foo", 20*time.Millisecond, func(ctx context.Context) (interface{}, error) {
+			return "bar", nil
+		})
+		if err != nil || v.(string) != "bar" {
+			tt.Errorf("loaded bar: %v %+v", v, err)
+		}
+		time.Sleep(30 * time.Millisecond)
+		if _, ok := c.Get("foo"); ok {
+			tt.Errorf("foo expired")
+		}
+	})
+	t.Run("context", func(tt *testing.T) {
+		c := New()
+		release := make(chan struct{})
+		defer close(release)
+
+		go c.GetOrLoad(context.Background(), "foo", func(ctx context.Context) (interface{}, error) {
+			<-release
+			return "bar", nil
+		})
+		time.Sleep(10 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := c.GetOrLoad(ctx, "foo", nil); err != context.DeadlineExceeded {
+			tt.Errorf("waiter canceled: %+v", err)
+		}
+	})
+	t.Run("canceled leader", func(tt *testing.T) {
+		c := New(WithLoadErrorTTL(time.Minute))
+		calls := int32(0)
+		loader := func(ctx context.Context) (interface{}, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return "bar", nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		leader := make(chan error)
+		go func() {
+			_, err := c.GetOrLoad(ctx, "foo", loader)
+			leader <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		waiter := make(chan interface{})
+		go func() {
+			v, err := c.GetOrLoad(context.Background(), "foo", loader)
+			if err != nil {
+				tt.Errorf("waiter with live context loads again: %+v", err)
+			}
+			waiter <- v
+		}()
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+
+		if err := <-leader; err != context.Canceled {
+			tt.Errorf("leader canceled: %+v", err)
+		}
+		if v := <-waiter; v != "bar" {
+			tt.Errorf("loaded by waiter: %v", v)
+		}
+		if n := atomic.LoadInt32(&calls); n != 2 {
+			tt.Errorf("loaded again: %d", n)
+		}
+	})
+	t.Run("panic", func(tt *testing.T) {
+		c := New()
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					tt.Errorf("panic propagated")
+				}
+			}()
+			c.GetOrLoad(context.Background(), "foo", func(ctx context.Context) (interface{}, error) {
+				panic("boom")
+			})
+		}()
+
+		v, err := c.GetOrLoad(context.Background(), "foo", func(ctx context.Context) (interface{}, error) {
+			return "bar", nil
+		})
+		if err != nil || v.(string) != "bar" {
+			tt.Errorf("in-flight call cleared after panic: %v %+v", v, err)
+		}
+	})
+}
@@ -0,0 +1,328 @@
+package cmap
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// compile check
+var (
+	_ Cache       = (*lruCache)(nil)
+	_ rangeCache  = (*lruCache)(nil)
+	_ expiryCache = (*lruCache)(nil)
+)
+
+// SizerFunc returns approximate memory size of entry in bytes
+type SizerFunc func(key string, value interface{}) int64
+
+// DefaultSizer counts length of key and []byte or string value, other values are counted as size of interface{}
+func DefaultSizer(key string, value interface{}) int64 {
+	switch v := value.(type) {
+	case []byte:
+		return int64(len(key) + len(v))
+	case string:
+		return int64(len(key) + len(v))
+	}
+	return int64(len(key) + 16)
+}
+
+type lruEntry struct {
+	key        string
+	value      interface{}
+	expire     int64
+	size       int64
+	referenced uint32
+}
+
+func (e *lruEntry) isExpired(now int64) bool {
+	return 0 < e.expire && e.expire <= now
+}
+
+// lruCache is bounded Cache, evicts least recently used entry when exceeds maxEntries or maxBytes.
+// zero maxEntries or maxBytes means no limit, bytes are tracked when sizer is set.
+// Get only marks entry as referenced under RLock, list is reordered under Lock when evicting:
+// referenced entry at back gets a second chance and moves to front (CLOCK).
+type lruCache struct {
+	mutex      *sync.RWMutex
+	values     map[string]*list.Element
+	ll         *list.List
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	budget     *byteBudget
+	sizer      SizerFunc
+	ttlCount   int
+	onEvict    OnEvictFunc
+}
+
+func (c *lruCache) Lock() {
+	c.mutex.Lock()
+}
+
+func (c *lruCache) TryLock() bool {
+	return c.mutex.TryLock()
+}
+
+func (c *lruCache) TryRLock() bool {
+	return c.mutex.TryRLock()
+}
+
+func (c *lruCache) RLock() {
+	c.mutex.RLock()
+}
+
+func (c *lruCache) Unlock() {
+	c.mutex.Unlock()
+}
+
+func (c *lruCache) RUnlock() {
+	c.mutex.RUnlock()
+}
+
+func (c *lruCache) SetOnEvict(fn OnEvictFunc) {
+	c.onEvict = fn
+}
+
+func (c *lruCache) evict(e *lruEntry, reason EvictReason) {
+	if c.onEvict == nil {
+		return
+	}
+	if e.isExpired(time.Now().UnixNano()) {
+		reason = EvictReasonExpired
+	}
+	c.onEvict(e.key, e.value, reason)
+}
+
+func (c *lruCache) Set(key string, value interface{}) {
+	c.set(key, value, 0)
+}
+
+func (c *lruCache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		c.set(key, value, 0)
+		return
+	}
+	c.set(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+func (c *lruCache) set(key string, value interface{}, expire int64) {
+	size := int64(0)
+	if c.sizer != nil {
+		size = c.sizer(key, value)
+	}
+
+	if elem, ok := c.values[key]; ok {
+		e := elem.Value.(*lruEntry)
+		c.evict(e, EvictReasonReplaced)
+		c.updateTTLCount(e.expire, expire)
+		c.addBytes(size - e.size)
+		e.value = value
+		e.expire = expire
+		e.size = size
+		c.ll.MoveToFront(elem)
+	} else {
+		c.updateTTLCount(0, expire)
+		c.addBytes(size)
+		c.values[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expire: expire, size: size})
+	}
+	for c.overCapacity() {
+		c.evict(c.removeElement(c.victim()), EvictReasonCapacity)
+	}
+	// entry larger than total budget never fits, other entries are evicted over budget by CMap
+	if c.budget != nil && c.budget.max < size {
+		if elem, ok := c.values[key]; ok {
+			c.evict(c.removeElement(elem), EvictReasonCapacity)
+		}
+	}
+}
+
+// EvictOldest evicts least recently used entry, reports false when empty
+func (c *lruCache) EvictOldest() bool {
+	if c.ll.Len() < 1 {
+		return false
+	}
+	c.evict(c.removeElement(c.victim()), EvictReasonCapacity)
+	return true
+}
+
+func (c *lruCache) addBytes(delta int64) {
+	atomic.AddInt64(&c.bytes, delta)
+	if c.budget != nil {
+		atomic.AddInt64(&c.budget.used, delta)
+	}
+}
+
+// victim returns least recently used element, referenced entries are moved to front on the way
+func (c *lruCache) victim() *list.Element {
+	for i := c.ll.Len(); 0 < i; i -= 1 {
+		elem := c.ll.Back()
+		e := elem.Value.(*lruEntry)
+		if atomic.LoadUint32(&e.referenced) == 0 {
+			return elem
+		}
+		atomic.StoreUint32(&e.referenced, 0)
+		c.ll.MoveToFront(elem)
+	}
+	return c.ll.Back()
+}
+
+// overCapacity reports whether entries exceed limits, entry larger than maxBytes is evicted too
+func (c *lruCache) overCapacity() bool {
+	if 0 < c.maxEntries && c.maxEntries < c.ll.Len() {
+		return true
+	}
+	return 0 < c.maxBytes && c.maxBytes < c.Bytes() && 0 < c.ll.Len()
+}
+
+// Bytes returns total size of entries measured by sizer, it may be read without lock
+func (c *lruCache) Bytes() int64 {
+	return atomic.LoadInt64(&c.bytes)
+}
+
+func (c *lruCache) updateTTLCount(oldExpire, newExpire int64) {
+	if 0 < oldExpire {
+		c.ttlCount -= 1
+	}
+	if 0 < newExpire {
+		c.ttlCount += 1
+	}
+}
+
+func (c *lruCache) removeElement(elem *list.Element) *lruEntry {
+	e := c.ll.Remove(elem).(*lruEntry)
+	delete(c.values, e.key)
+	c.updateTTLCount(e.expire, 0)
+	c.addBytes(-e.size)
+	return e
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	elem, ok := c.values[key]
+	if ok != true {
+		return nil, false
+	}
+	e := elem.Value.(*lruEntry)
+	if e.isExpired(time.Now().UnixNano()) {
+		return nil, false
+	}
+
+	if atomic.LoadUint32(&e.referenced) == 0 {
+		atomic.StoreUint32(&e.referenced, 1)
+	}
+	return e.value, true
+}
+
+func (c *lruCache) TTL(key string) (time.Duration, bool) {
+	elem, ok := c.values[key]
+	if ok != true {
+		return 0, false
+	}
+	e := elem.Value.(*lruEntry)
+	if e.expire == 0 {
+		return 0, false
+	}
+	return time.Duration(e.expire - time.Now().UnixNano()), true
+}
+
+func (c *lruCache) Remove(key string) (interface{}, bool) {
+	elem, ok := c.values[key]
+	if ok != true {
+		return nil, false
+	}
+	e := c.removeElement(elem)
+	c.evict(e, EvictReasonRemoved)
+	if e.isExpired(time.Now().UnixNano()) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *lruCache) RemoveExpired() int {
+	if c.ttlCount < 1 {
+		return 0
+	}
+
+	now := time.Now().UnixNano()
+	removed := 0
+	for elem := c.ll.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*lruEntry).isExpired(now) {
+			c.evict(c.removeElement(elem), EvictReasonExpired)
+			removed += 1
+		}
+		elem = next
+	}
+	return removed
+}
+
+func (c *lruCache) Len() int {
+	return c.ll.Len()
+}
+
+func (c *lruCache) Keys() []string {
+	now := time.Now().UnixNano()
+	keys := make([]string, 0, c.ll.Len())
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*lruEntry)
+		if e.isExpired(now) {
+			continue
+		}
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+func (c *lruCache) Range(fn RangeFunc) {
+	now := time.Now().UnixNano()
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*lruEntry)
+		if e.isExpired(now) {
+			continue
+		}
+		if fn(e.key, e.value) != true {
+			return
+		}
+	}
+}
+
+func (c *lruCache) rangeExpiry(fn func(key string, value interface{}, expireAt int64) bool) {
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*lruEntry)
+		if fn(e.key, e.value, e.expire) != true {
+			return
+		}
+	}
+}
+
+func NewLRUCache(capacity int, maxEntries int) Cache {
+	return newLRUCache(capacity, maxEntries)
+}
+
+// NewLRUCacheWithMaxBytes returns LRU Cache limited by total size of entries measured by sizer.
+// zero maxEntries means no limit of number of entries, DefaultSizer is used when sizer is nil.
+func NewLRUCacheWithMaxBytes(capacity int, maxEntries int, maxBytes int64, sizer SizerFunc) Cache {
+	if sizer == nil {
+		sizer = DefaultSizer
+	}
+	return newLRUCacheWithSizer(capacity, maxEntries, maxBytes, sizer)
+}
+
+func newLRUCache(size int, maxEntries int) *lruCache {
+	return newLRUCacheWithSizer(size, maxEntries, 0, nil)
+}
+
+func newLRUCacheWithSizer(size int, maxEntries int, maxBytes int64, sizer SizerFunc) *lruCache {
+	if 0 < maxEntries && maxEntries < size {
+		size = maxEntries
+	}
+	return &lruCache{
+		mutex:      new(sync.RWMutex),
+		values:     make(map[string]*list.Element, size),
+		ll:         list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		sizer:      sizer,
+	}
+}
@@ -0,0 +1,213 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvict(t *testing.T) {
+	c := newLRUCache(16, 3)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	if c.Len() != 3 {
+		t.Errorf("3 keys set")
+	}
+
+	// a is most recently used
+	if _, ok := c.Get("a"); ok != true {
+		t.Errorf("a exists")
+	}
+	c.Set("d", 4)
+
+	if c.Len() != 3 {
+		t.Errorf("bounded 3 keys: %d", c.Len())
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("b is least recently used, evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(k); ok != true {
+			t.Errorf("%s exists", k)
+		}
+	}
+
+	// overwrite does not evict
+	c.Set("c", 30)
+	if c.Len() != 3 {
+		t.Errorf("bounded 3 keys: %d", c.Len())
+	}
+	if v, _ := c.Get("c"); v.(int) != 30 {
+		t.Errorf("c updated")
+	}
+
+	if v, ok := c.Remove("a"); ok != true || v.(int) != 1 {
+		t.Errorf("a removed")
+	}
+	if c.Len() != 2 {
+		t.Errorf("2 keys remains")
+	}
+}
+
+func TestLRUCacheMaxBytes(t *testing.T) {
+	t.Run("evict", func(tt *testing.T) {
+		c := newLRUCacheWithSizer(16, 0, 10, func(key string, value interface{}) int64 {
+			return int64(len(value.(string)))
+		})
+		c.Set("a", "aaaa")
+		c.Set("b", "bbbb")
+		if c.Bytes() != 8 {
+			tt.Errorf("8 bytes: %d", c.Bytes())
+		}
+		c.Get("a")
+		c.Set("c", "cccc")
+		if _, ok := c.Get("b"); ok {
+			tt.Errorf("b is least recently used, evicted")
+		}
+		if c.Bytes() != 8 || c.Len() != 2 {
+			tt.Errorf("over budget evicted: %d bytes %d keys", c.Bytes(), c.Len())
+		}
+
+		// grow by replace
+		c.Set("c", "cccccccc")
+		if _, ok := c.Get("a"); ok {
+			tt.Errorf("a evicted by replaced larger value")
+		}
+		if c.Bytes() != 8 {
+			tt.Errorf("replaced size: %d", c.Bytes())
+		}
+
+		c.Remove("c")
+		if c.Bytes() != 0 {
+			tt.Errorf("removed size: %d", c.Bytes())
+		}
+	})
+	t.Run("oversize", func(tt *testing.T) {
+		c := newLRUCacheWithSizer(16, 0, 10, DefaultSizer)
+		evicted := make([]EvictReason, 0)
+		c.SetOnEvict(func(key string, value interface{}, reason EvictReason) {
+			evicted = append(evicted, reason)
+		})
+		c.Set("a", "1234567890")
+		if c.Len() != 0 || c.Bytes() != 0 {
+			tt.Errorf("entry larger than budget evicted: %d %d", c.Len(), c.Bytes())
+		}
+		if len(evicted) != 1 || evicted[0] != EvictReasonCapacity {
+			tt.Errorf("capacity eviction: %v", evicted)
+		}
+	})
+	t.Run("ttl", func(tt *testing.T) {
+		c := newLRUCacheWithSizer(16, 0, 0, DefaultSizer)
+		c.SetWithTTL("a", "bb", 10*time.Millisecond)
+		c.Set("b", []byte("ccc"))
+		if c.Bytes() != 7 {
+			tt.Errorf("key + value: %d", c.Bytes())
+		}
+		time.Sleep(20 * time.Millisecond)
+		c.RemoveExpired()
+		if c.Bytes() != 4 {
+			tt.Errorf("expired size: %d", c.Bytes())
+		}
+	})
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	c := newLRUCache(0, 10)
+	c.SetWithTTL("foo", "bar", 30*time.Millisecond)
+	c.Set("hello", "world")
+
+	if _, ok := c.Get("foo"); ok != true {
+		t.Errorf("foo not expired")
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	if _, ok := c.Get("foo"); ok {
+		t.Errorf("foo expired")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expired key counted until removed: %d", c.Len())
+	}
+	if ks := c.Keys(); len(ks) != 1 || ks[0] != "hello" {
+		t.Errorf("expired key not listed: %v", ks)
+	}
+	if removed := c.RemoveExpired(); removed != 1 {
+		t.Errorf("1 key expired: %d", removed)
+	}
+	if c.Len() != 1 {
+		t.Errorf("expired key removed: %d", c.Len())
+	}
+	if c.ttlCount != 0 {
+		t.Errorf("no ttl entries: %d", c.ttlCount)
+	}
+}
+
+func TestLRUCacheConcurrentGet(t *testing.T) {
+	c := newLRUCache(0, 100)
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j += 1 {
+				c.RLock()
+				c.Get(strconv.Itoa(j % 100))
+				c.RUnlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c.Len() != 100 {
+		t.Errorf("no eviction on get")
+	}
+}
+
+func TestLRUCacheConcurrentGetRange(t *testing.T) {
+	c := New(WithSlabSize(4), WithMaxEntries(100))
+	for i := 0; i < 200; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i += 1 {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j += 1 {
+				c.Get(strconv.Itoa(j % 200))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j += 1 {
+				c.Range(func(key string, value interface{}) bool {
+					return true
+				})
+				c.Keys()
+				c.Len()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j += 1 {
+				c.Set(strconv.Itoa(j%300), j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if 100 < c.Len() {
+		t.Errorf("bounded 100 entries: %d", c.Len())
+	}
+}
@@ -0,0 +1,286 @@
+package cmap
+
+import (
+	"sync"
+)
+
+type MapGetFunc[V any] func(exists bool, value V) (processedValue V)
+
+type MapUpsertFunc[V any] func(exists bool, oldValue V) (newValue V)
+
+type MapSetIfFunc[V any] func(exists bool, value V) (newValue V, isSetValue bool)
+
+type MapRemoveIfFunc[V any] func(exists bool, value V) bool
+
+// Map is type-parameterized version of CMap.
+// keys and values are stored without boxing into interface{}, so Map has its own typed shards
+// instead of slab and Cache of CMap, which hold string key and interface{} value.
+// TTL, LRU, OnEvict and Stats are CMap features and not available on Map.
+// Map uses WithSlabSize, WithCacheCapacity and WithHashFunc, other options are ignored.
+type Map[K comparable, V any] struct {
+	s *typedSlab[K, V]
+}
+
+// newMapOption applies funcs for Map and SyncMap, hashFunc is nil unless WithHashFunc is set
+func newMapOption(funcs []cmapOptionFunc) *cmapOption {
+	opt := newDefaultOption()
+	opt.hashFunc = nil
+	for _, fn := range funcs {
+		fn(opt)
+	}
+	return opt
+}
+
+func (opt *cmapOption) mapHashFunc() CMapHashFunc {
+	if opt.hashFunc == nil {
+		return NewXXHashFunc()
+	}
+	return opt.hashFunc
+}
+
+func NewMap[V any](funcs ...cmapOptionFunc) *Map[string, V] {
+	opt := newMapOption(funcs)
+	return &Map[string, V]{
+		s: newTypedSlab[string, V](opt, NewStringKeyHashFunc(opt.mapHashFunc())),
+	}
+}
+
+// NewMapWithKeyHashFunc hashes keys by hashFunc.
+// WithHashFunc replaces CMapHashFunc of key hash funcs created by New*KeyHashFunc, custom CMapKeyHashFunc is used as is.
+func NewMapWithKeyHashFunc[K comparable, V any](hashFunc CMapKeyHashFunc[K], funcs ...cmapOptionFunc) *Map[K, V] {
+	opt := newMapOption(funcs)
+	if opt.hashFunc != nil {
+		if h, ok := hashFunc.(keyHashFuncReplacer[K]); ok {
+			hashFunc = h.withHashFunc(opt.hashFunc)
+		}
+	}
+	return &Map[K, V]{
+		s: newTypedSlab[K, V](opt, hashFunc),
+	}
+}
+
+func (c *Map[K, V]) Set(key K, value V) {
+	m := c.s.GetShard(key)
+	m.Lock()
+	defer m.Unlock()
+
+	m.Set(key, value)
+}
+
+func (c *Map[K, V]) Get(key K) (V, bool) {
+	m := c.s.GetShard(key)
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.Get(key)
+}
+
+func (c *Map[K, V]) GetRLocked(key K, fn MapGetFunc[V]) V {
+	m := c.s.GetShard(key)
+	m.RLock()
+	defer m.RUnlock()
+
+	v, ok := m.Get(key)
+	return fn(ok, v)
+}
+
+func (c *Map[K, V]) Remove(key K) (V, bool) {
+	m := c.s.GetShard(key)
+	m.Lock()
+	defer m.Unlock()
+
+	return m.Remove(key)
+}
+
+func (c *Map[K, V]) Len() int {
+	count := 0
+	for _, m := range c.s.Shards() {
+		m.RLock()
+		count += m.Len()
+		m.RUnlock()
+	}
+	return count
+}
+
+func (c *Map[K, V]) Keys() []K {
+	shards := c.s.Shards()
+	keys := make([]K, 0, len(shards))
+	for _, m := range shards {
+		m.RLock()
+		keys = append(keys, m.Keys()...)
+		m.RUnlock()
+	}
+	return keys
+}
+
+func (c *Map[K, V]) Range(fn func(key K, value V) bool) {
+	for _, m := range c.s.Shards() {
+		next := true
+		m.RLock()
+		for k, v := range m.values {
+			if next = fn(k, v); next != true {
+				break
+			}
+		}
+		m.RUnlock()
+
+		if next != true {
+			return
+		}
+	}
+}
+
+func (c *Map[K, V]) RangeCopy(fn func(key K, value V) bool) {
+	keys := make([]K, 0, 64)
+	values := make([]V, 0, 64)
+	for _, m := range c.s.Shards() {
+		keys, values = keys[:0], values[:0]
+
+		m.RLock()
+		for k, v := range m.values {
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+		m.RUnlock()
+
+		for i := range keys {
+			if fn(keys[i], values[i]) != true {
+				return
+			}
+		}
+	}
+}
+
+func (c *Map[K, V]) Upsert(key K, fn MapUpsertFunc[V]) (newValue V) {
+	m := c.s.GetShard(key)
+	m.Lock()
+	defer m.Unlock()
+
+	oldValue, ok := m.Get(key)
+	newValue = fn(ok, oldValue)
+	m.Set(key, newValue)
+	return
+}
+
+func (c *Map[K, V]) SetIfAbsent(key K, value V) (updated bool) {
+	m := c.s.GetShard(key)
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.Get(key); ok != true {
+		m.Set(key, value)
+		return true
+	}
+	return false
+}
+
+func (c *Map[K, V]) SetIf(key K, fn MapSetIfFunc[V]) (updated bool) {
+	m := c.s.GetShard(key)
+	m.Lock()
+	defer m.Unlock()
+
+	v, ok := m.Get(key)
+	setValue, isSet := fn(ok, v)
+	if isSet {
+		m.Set(key, setValue)
+		return true
+	}
+	return false
+}
+
+func (c *Map[K, V]) RemoveIf(key K, fn MapRemoveIfFunc[V]) (removed bool) {
+	m := c.s.GetShard(key)
+	m.Lock()
+	defer m.Unlock()
+
+	v, ok := m.Get(key)
+	remove := fn(ok, v)
+	if remove && ok {
+		m.Remove(key)
+		return true
+	}
+	return false
+}
+
+type typedSlab[K comparable, V any] struct {
+	shards []*typedCache[K, V]
+	mask   uint64
+	hash   CMapKeyHashFunc[K]
+}
+
+func newTypedSlab[K comparable, V any](opt *cmapOption, hash CMapKeyHashFunc[K]) *typedSlab[K, V] {
+	size := opt.shardCount()
+	shards := make([]*typedCache[K, V], size)
+	for i := 0; i < size; i += 1 {
+		shards[i] = newTypedCache[K, V](opt.cacheCapacity)
+	}
+	return &typedSlab[K, V]{
+		shards: shards,
+		mask:   uint64(size - 1),
+		hash:   hash,
+	}
+}
+
+func (s *typedSlab[K, V]) GetShard(key K) *typedCache[K, V] {
+	idx := int(mixShard(s.hash.HashKey(key)) & s.mask)
+	return s.shards[idx]
+}
+
+func (s *typedSlab[K, V]) Shards() []*typedCache[K, V] {
+	return s.shards
+}
+
+type typedCache[K comparable, V any] struct {
+	mutex  *sync.RWMutex
+	values map[K]V
+}
+
+func (c *typedCache[K, V]) Lock() {
+	c.mutex.Lock()
+}
+
+func (c *typedCache[K, V]) RLock() {
+	c.mutex.RLock()
+}
+
+func (c *typedCache[K, V]) Unlock() {
+	c.mutex.Unlock()
+}
+
+func (c *typedCache[K, V]) RUnlock() {
+	c.mutex.RUnlock()
+}
+
+func (c *typedCache[K, V]) Set(key K, value V) {
+	c.values[key] = value
+}
+
+func (c *typedCache[K, V]) Get(key K) (V, bool) {
+	v, ok := c.values[key]
+	return v, ok
+}
+
+func (c *typedCache[K, V]) Remove(key K) (V, bool) {
+	v, ok := c.values[key]
+	delete(c.values, key)
+	return v, ok
+}
+
+func (c *typedCache[K, V]) Len() int {
+	return len(c.values)
+}
+
+func (c *typedCache[K, V]) Keys() []K {
+	keys := make([]K, 0, len(c.values))
+	for k, _ := range c.values {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func newTypedCache[K comparable, V any](size int) *typedCache[K, V] {
+	return &typedCache[K, V]{
+		mutex:  new(sync.RWMutex),
+		values: make(map[K]V, size),
+	}
+}
@@ -0,0 +1,356 @@
+package cmap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func BenchmarkMapCompare(b *testing.B) {
+	keys := make([]string, 10000)
+	for i := 0; i < len(keys); i += 1 {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	b.Run("CMap", func(tb *testing.B) {
+		c := New()
+		tb.ResetTimer()
+		for i := 0; i < tb.N; i += 1 {
+			for n, key := range keys {
+				c.Set(key, n)
+			}
+			for _, key := range keys {
+				v, _ := c.Get(key)
+				_ = v.(int)
+			}
+		}
+	})
+	b.Run("Map", func(tb *testing.B) {
+		c := NewMap[int]()
+		tb.ResetTimer()
+		for i := 0; i < tb.N; i += 1 {
+			for n, key := range keys {
+				c.Set(key, n)
+			}
+			for _, key := range keys {
+				c.Get(key)
+			}
+		}
+	})
+}
+
+func TestMapSetGetRemove(t *testing.T) {
+	c := NewMap[int]()
+	if _, ok := c.Get("foobar"); ok {
+		t.Errorf("key foobar not exists")
+	}
+	if _, ok := c.Remove("foobar"); ok {
+		t.Errorf("key foobar not exists")
+	}
+
+	c.Set("foobar", 123456)
+	c.Set("hello", 1)
+
+	if v, ok := c.Get("foobar"); ok != true {
+		t.Errorf("foobar exists")
+	} else {
+		if v != 123456 {
+			t.Errorf("value is 123456")
+		}
+	}
+
+	processed := c.GetRLocked("foobar", func(ok bool, v int) int {
+		if ok != true {
+			t.Errorf("foobar exists")
+		}
+		if v != 123456 {
+			t.Errorf("value is 123456")
+		}
+		return v + 1
+	})
+	if processed != 123457 {
+		t.Errorf("processed value returned: %d", processed)
+	}
+
+	if old, ok := c.Remove("foobar"); ok != true {
+		t.Errorf("foobar exists")
+	} else {
+		if old != 123456 {
+			t.Errorf("old value is 123456")
+		}
+	}
+
+	if v, ok := c.Get("foobar"); ok {
+		t.Errorf("foobar removed")
+	} else {
+		if v != 0 {
+			t.Errorf("zero value returned: %d", v)
+		}
+	}
+	if _, ok := c.Get("hello"); ok != true {
+		t.Errorf("key hello not removed")
+	}
+}
+
+func TestMapLenKeys(t *testing.T) {
+	c := NewMap[string](WithSlabSize(32))
+
+	if c.Len() != 0 {
+		t.Errorf("no keys")
+	}
+
+	size := 1000
+	for i := 0; i < size; i += 1 {
+		key := strconv.Itoa(i)
+		c.Set(key, key)
+	}
+	if c.Len() != size {
+		t.Errorf("%d key set", size)
+	}
+	if len(c.Keys()) != size {
+		t.Errorf("%d key set", size)
+	}
+
+	for i := 0; i < size/2; i += 1 {
+		c.Remove(strconv.Itoa(i))
+	}
+	if c.Len() != size/2 {
+		t.Errorf("%d key set", size/2)
+	}
+}
+
+func TestMapUpsert(t *testing.T) {
+	c := NewMap[[]string]()
+
+	v := c.Upsert("foo", func(exists bool, oldValue []string) []string {
+		if exists {
+			t.Errorf("foo not exists")
+		}
+		if oldValue != nil {
+			t.Errorf("old value is zero value")
+		}
+		return append(oldValue, "bar")
+	})
+	if len(v) != 1 || v[0] != "bar" {
+		t.Errorf("new value is [bar]: %v", v)
+	}
+
+	v = c.Upsert("foo", func(exists bool, oldValue []string) []string {
+		if exists != true {
+			t.Errorf("foo exists")
+		}
+		return append(oldValue, "baz")
+	})
+	if len(v) != 2 || v[1] != "baz" {
+		t.Errorf("new value is [bar baz]: %v", v)
+	}
+}
+
+func TestMapSetIfAbsent(t *testing.T) {
+	c := NewMap[string]()
+	c.Set("foo", "bar")
+
+	if c.SetIfAbsent("foo", "12345") {
+		t.Errorf("foo exists")
+	}
+	if c.SetIfAbsent("foobar", "12345") != true {
+		t.Errorf("no key = updated")
+	}
+	if v, _ := c.Get("foo"); v != "bar" {
+		t.Errorf("not updated")
+	}
+	if v, _ := c.Get("foobar"); v != "12345" {
+		t.Errorf("updated value 12345")
+	}
+}
+
+func TestMapSetIf(t *testing.T) {
+	c := NewMap[int]()
+	c.Set("foo", 1)
+
+	if c.SetIf("foo", func(exists bool, v int) (int, bool) {
+		if exists != true {
+			t.Errorf("foo exists")
+		}
+		return v + 1, false
+	}) {
+		t.Errorf("not updated")
+	}
+	if v, _ := c.Get("foo"); v != 1 {
+		t.Errorf("not updated")
+	}
+
+	if c.SetIf("foo", func(exists bool, v int) (int, bool) {
+		return v + 1, true
+	}) != true {
+		t.Errorf("updated")
+	}
+	if v, _ := c.Get("foo"); v != 2 {
+		t.Errorf("updated 2")
+	}
+}
+
+func TestMapRemoveIf(t *testing.T) {
+	c := NewMap[int]()
+	c.Set("foo", 1)
+
+	if c.RemoveIf("foo", func(exists bool, v int) bool {
+		return v != 1
+	}) {
+		t.Errorf("not removed")
+	}
+	if c.RemoveIf("foo", func(exists bool, v int) bool {
+		return v == 1
+	}) != true {
+		t.Errorf("removed")
+	}
+	if c.RemoveIf("foo", func(exists bool, v int) bool {
+		if exists {
+			t.Errorf("foo already removed")
+		}
+		return true
+	}) {
+		t.Errorf("not exists")
+	}
+}
+
+func TestMapNonStringKey(t *testing.T) {
+	t.Run("integer", func(tt *testing.T) {
+		c := NewMapWithKeyHashFunc[uint64, string](NewIntegerKeyHashFunc[uint64](NewXXHashFunc()))
+		for i := uint64(0); i < 100; i += 1 {
+			c.Set(i, strconv.FormatUint(i, 10))
+		}
+		if c.Len() != 100 {
+			tt.Errorf("100 keys set")
+		}
+		if v, ok := c.Get(42); ok != true || v != "42" {
+			tt.Errorf("key 42 = 42: %s", v)
+		}
+	})
+	t.Run("uuid", func(tt *testing.T) {
+		c := NewMapWithKeyHashFunc[[16]byte, int](NewUUIDKeyHashFunc(NewXXHashFunc()))
+		id := [16]byte{0x01, 0x02, 0x03}
+		c.Set(id, 1)
+		if v, ok := c.Get(id); ok != true || v != 1 {
+			tt.Errorf("uuid key exists")
+		}
+		if _, ok := c.Get([16]byte{}); ok {
+			tt.Errorf("zero uuid not exists")
+		}
+	})
+	t.Run("struct", func(tt *testing.T) {
+		type compositeKey struct {
+			tenant string
+			id     int
+		}
+		c := NewMapWithKeyHashFunc[compositeKey, int](NewComparableKeyHashFunc[compositeKey](NewXXHashFunc()))
+		c.Set(compositeKey{"foo", 1}, 1)
+		c.Set(compositeKey{"foo", 2}, 2)
+		c.Set(compositeKey{"bar", 1}, 3)
+		if c.Len() != 3 {
+			tt.Errorf("3 keys set")
+		}
+		if v, _ := c.Get(compositeKey{"bar", 1}); v != 3 {
+			tt.Errorf("bar/1 = 3: %d", v)
+		}
+	})
+}
+
+func TestMapRange(t *testing.T) {
+	c := NewMap[int](WithSlabSize(8))
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+
+	sum := 0
+	c.Range(func(key string, value int) bool {
+		sum += value
+		return true
+	})
+	if sum != 4950 {
+		t.Errorf("visit all entries: %d", sum)
+	}
+
+	count := 0
+	c.Range(func(key string, value int) bool {
+		count += 1
+		return count < 10
+	})
+	if count != 10 {
+		t.Errorf("stop at 10: %d", count)
+	}
+
+	count = 0
+	c.RangeCopy(func(key string, value int) bool {
+		c.Set(key, value+1)
+		count += 1
+		return true
+	})
+	if count != 100 {
+		t.Errorf("visit all entries: %d", count)
+	}
+	if v, _ := c.Get("0"); v != 1 {
+		t.Errorf("updated in callback: %d", v)
+	}
+}
+
+func TestMapOption(t *testing.T) {
+	t.Run("supported", func(tt *testing.T) {
+		c := NewMap[int](WithSlabSize(4), WithCacheCapacity(8), WithHashFunc(NewFNV64HashFun()))
+		if len(c.s.Shards()) != 4 {
+			tt.Errorf("slab size 4: %d", len(c.s.Shards()))
+		}
+	})
+	t.Run("ignored", func(tt *testing.T) {
+		for name, fn := range map[string]cmapOptionFunc{
+			"WithDefaultTTL": WithDefaultTTL(time.Nanosecond),
+			"WithMaxEntries": WithMaxEntries(10),
+			"WithOnEvict":    WithOnEvict(func(string, interface{}, EvictReason) {}),
+			"WithCacheFactory": WithCacheFactory(func(capacity int) Cache {
+				return NewDefaultCache(capacity)
+			}),
+			"WithCodec": WithCodec(NewJSONCodec()),
+		} {
+			c := NewMap[int](fn)
+			for i := 0; i < 100; i += 1 {
+				c.Set(strconv.Itoa(i), i)
+			}
+			time.Sleep(time.Millisecond)
+			if c.Len() != 100 {
+				tt.Errorf("%s ignored: %d", name, c.Len())
+			}
+		}
+	})
+	t.Run("hash func", func(tt *testing.T) {
+		h := &testCountingHashFunc{CMapHashFunc: NewXXHashFunc()}
+		s := NewMap[int](WithHashFunc(h))
+		s.Set("foo", 1)
+		if h.count != 1 {
+			tt.Errorf("WithHashFunc used by NewMap: %d", h.count)
+		}
+
+		h = &testCountingHashFunc{CMapHashFunc: NewXXHashFunc()}
+		u := NewMapWithKeyHashFunc[[16]byte, int](NewUUIDKeyHashFunc(NewXXHashFunc()), WithHashFunc(h))
+		u.Set([16]byte{1}, 1)
+		if h.count != 1 {
+			tt.Errorf("WithHashFunc replaces hash func of key hash func: %d", h.count)
+		}
+
+		h = &testCountingHashFunc{CMapHashFunc: NewXXHashFunc()}
+		k := NewMapWithKeyHashFunc[string, int](NewStringKeyHashFunc(h))
+		k.Set("foo", 1)
+		if h.count != 1 {
+			tt.Errorf("key hash func kept without WithHashFunc: %d", h.count)
+		}
+	})
+}
+
+type testCountingHashFunc struct {
+	CMapHashFunc
+	count int
+}
+
+func (h *testCountingHashFunc) Hash64(key string) uint64 {
+	h.count += 1
+	return h.CMapHashFunc.Hash64(key)
+}
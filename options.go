@@ -1,16 +1,46 @@
 package cmap
 
+import (
+	"math/bits"
+	"time"
+)
+
 const (
-	defaultSlabSize      int = 1024
-	defaultCacheCapacity int = 64
+	defaultSlabSize        int = 1024
+	defaultCacheCapacity   int = 64
+	defaultWatchBufferSize int = 64
+	minEntriesPerShard     int = 8
+
+	defaultWALSyncInterval time.Duration = time.Second
 )
 
 type cmapOptionFunc func(*cmapOption)
 
 type cmapOption struct {
+	name          string
 	slabSize      int
 	cacheCapacity int
 	hashFunc      CMapHashFunc
+	defaultTTL    time.Duration
+	sweepInterval time.Duration
+	maxEntries    int
+	shardEntries  int
+	maxBytes      int64
+	sizer         SizerFunc
+	onEvict       OnEvictFunc
+	cacheFactory  CacheFactory
+	loadErrorTTL  time.Duration
+	codec         Codec
+
+	watchBufferSize int
+	watchDropPolicy WatchDropPolicy
+
+	autoResizeInterval    time.Duration
+	autoResizeMaxShardLen int
+
+	walSyncPolicy   WALSyncPolicy
+	walSyncInterval time.Duration
+	onWALError      WALErrorFunc
 }
 
 func newDefaultOption() *cmapOption {
@@ -18,9 +48,91 @@ func newDefaultOption() *cmapOption {
 		slabSize:      defaultSlabSize,
 		cacheCapacity: defaultCacheCapacity,
 		hashFunc:      NewXXHashFunc(),
+		codec:         NewGobCodec(),
+
+		watchBufferSize: defaultWatchBufferSize,
+		watchDropPolicy: WatchDropNewest,
+
+		walSyncPolicy:   WALSyncAlways,
+		walSyncInterval: defaultWALSyncInterval,
+	}
+}
+
+func (opt *cmapOption) shardCount() int {
+	return opt.shardCountOf(opt.slabSize)
+}
+
+// shardCountOf normalizes slabSize to power of two, shard is selected by bitmask.
+// WithMaxEntries reduces shards so that each shard holds at least minEntriesPerShard entries.
+func (opt *cmapOption) shardCountOf(slabSize int) int {
+	size := 1
+	if 1 < slabSize {
+		size = 1 << bits.Len(uint(slabSize-1))
+	}
+	if opt.boundedByMaxEntries() {
+		for 1 < size && opt.maxEntries < size*minEntriesPerShard {
+			size = size / 2
+		}
+	}
+	return size
+}
+
+func (opt *cmapOption) boundedByMaxEntries() bool {
+	return 0 < opt.maxEntries && opt.shardEntries < 1 && opt.cacheFactory == nil
+}
+
+// maxEntriesOfShard divides maxEntries into shards, sum of all shards is exactly maxEntries
+func (opt *cmapOption) maxEntriesOfShard(index int) int {
+	if 0 < opt.shardEntries {
+		return opt.shardEntries
+	}
+	if 0 < opt.maxEntries {
+		size := opt.shardCount()
+		n := opt.maxEntries / size
+		if index < opt.maxEntries%size {
+			n += 1
+		}
+		return n
+	}
+	return 0
+}
+
+// newByteBudget returns budget shared by shards of a slab, entries of custom cache are not measured
+func (opt *cmapOption) newByteBudget() *byteBudget {
+	if opt.maxBytes < 1 || opt.cacheFactory != nil {
+		return nil
 	}
+	return &byteBudget{max: opt.maxBytes}
 }
 
+func (opt *cmapOption) newCache(index int, budget *byteBudget) Cache {
+	if opt.cacheFactory != nil {
+		return opt.cacheFactory(opt.cacheCapacity)
+	}
+	maxEntries := opt.maxEntriesOfShard(index)
+	if budget != nil || opt.sizer != nil {
+		sizer := opt.sizer
+		if sizer == nil {
+			sizer = DefaultSizer
+		}
+		c := newLRUCacheWithSizer(opt.cacheCapacity, maxEntries, 0, sizer)
+		c.budget = budget
+		return c
+	}
+	if 0 < maxEntries {
+		return newLRUCache(opt.cacheCapacity, maxEntries)
+	}
+	return NewDefaultCache(opt.cacheCapacity)
+}
+
+// WithName sets name of the map, used as label of metrics
+func WithName(name string) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.name = name
+	}
+}
+
+// WithSlabSize sets number of shards, rounded up to power of two.
 func WithSlabSize(size int) cmapOptionFunc {
 	return func(opt *cmapOption) {
 		opt.slabSize = size
@@ -33,8 +145,118 @@ func WithCacheCapacity(size int) cmapOptionFunc {
 	}
 }
 
+// WithMaxEntries limits total number of entries, divided into shards.
+// each shard evicts least recently used entry when exceeds its limit, slab size is reduced for small limit.
+// number of shards never exceeds size/8 even by Resize or WithAutoResize.
+func WithMaxEntries(size int) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.maxEntries = size
+	}
+}
+
+// WithMaxEntriesPerShard limits number of entries per shard, takes precedence over WithMaxEntries.
+func WithMaxEntriesPerShard(size int) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.shardEntries = size
+	}
+}
+
+// WithMaxBytes limits total size of entries measured by sizer, DefaultSizer is used unless WithSizer.
+// when total exceeds the limit, least recently used entries of shards are evicted in turn.
+func WithMaxBytes(size int64) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.maxBytes = size
+	}
+}
+
+// WithSizer sets func that measures size of entry, Stats reports bytes of each shard when set.
+func WithSizer(fn SizerFunc) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.sizer = fn
+	}
+}
+
 func WithHashFunc(hashFunc CMapHashFunc) cmapOptionFunc {
 	return func(opt *cmapOption) {
 		opt.hashFunc = hashFunc
 	}
 }
+
+func WithDefaultTTL(ttl time.Duration) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.defaultTTL = ttl
+	}
+}
+
+// WithSweepInterval runs background sweeper that removes expired entries shard by shard.
+// sweeper stops on CMap.Close()
+func WithSweepInterval(interval time.Duration) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.sweepInterval = interval
+	}
+}
+
+func WithOnEvict(fn OnEvictFunc) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.onEvict = fn
+	}
+}
+
+// WithCacheFactory replaces shard backend, takes precedence over WithMaxEntries.
+func WithCacheFactory(factory CacheFactory) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.cacheFactory = factory
+	}
+}
+
+// WithLoadErrorTTL caches loader error of GetOrLoad for ttl, errors are not cached by default.
+func WithLoadErrorTTL(ttl time.Duration) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.loadErrorTTL = ttl
+	}
+}
+
+// WithWatchBufferSize sets event buffer size of each subscription
+func WithWatchBufferSize(size int) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.watchBufferSize = size
+	}
+}
+
+func WithWatchDropPolicy(policy WatchDropPolicy) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.watchDropPolicy = policy
+	}
+}
+
+// WithAutoResize checks average shard length every interval, and doubles slab size when exceeds maxAvgShardLen.
+// it stops when slab size can not grow any more by WithMaxEntries.
+func WithAutoResize(interval time.Duration, maxAvgShardLen int) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.autoResizeInterval = interval
+		opt.autoResizeMaxShardLen = maxAvgShardLen
+	}
+}
+
+// WithCodec sets value codec used by SaveTo and LoadFrom, default is gob
+func WithCodec(codec Codec) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.codec = codec
+	}
+}
+
+// WithWALSync sets fsync policy of write-ahead log opened by Open, interval is used by WALSyncInterval
+func WithWALSync(policy WALSyncPolicy, interval time.Duration) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.walSyncPolicy = policy
+		opt.walSyncInterval = interval
+	}
+}
+
+// WithOnWALError sets func called once when write-ahead log opened by Open stops by an error.
+// it is called outside the shard lock, so it may access the map.
+func WithOnWALError(fn WALErrorFunc) cmapOptionFunc {
+	return func(opt *cmapOption) {
+		opt.onWALError = fn
+	}
+}
@@ -2,6 +2,7 @@ package cmap
 
 import (
 	"testing"
+	"time"
 )
 
 func TestDefaultOption(t *testing.T) {
@@ -16,4 +17,111 @@ func TestDefaultOption(t *testing.T) {
 	if d.hashFunc == nil {
 		t.Errorf("default hash func not nil")
 	}
+	if d.watchBufferSize != defaultWatchBufferSize {
+		t.Errorf("default watch buffer size = %d", defaultWatchBufferSize)
+	}
+	if d.watchDropPolicy != WatchDropNewest {
+		t.Errorf("default drop newest")
+	}
+}
+
+func TestTTLOption(t *testing.T) {
+	d := newDefaultOption()
+	if d.defaultTTL != 0 {
+		t.Errorf("default no ttl")
+	}
+	if d.sweepInterval != 0 {
+		t.Errorf("default no sweeper")
+	}
+
+	opt := newDefaultOption()
+	WithDefaultTTL(time.Second)(opt)
+	WithSweepInterval(time.Minute)(opt)
+	if opt.defaultTTL != time.Second {
+		t.Errorf("ttl = 1s")
+	}
+	if opt.sweepInterval != time.Minute {
+		t.Errorf("sweep interval = 1m")
+	}
+}
+
+func TestMaxEntriesOption(t *testing.T) {
+	d := newDefaultOption()
+	if d.maxEntriesOfShard(0) != 0 {
+		t.Errorf("default unbounded")
+	}
+
+	opt := newDefaultOption()
+	WithSlabSize(8)(opt)
+	WithMaxEntries(100)(opt)
+	if opt.maxEntriesOfShard(0) != 13 || opt.maxEntriesOfShard(7) != 12 {
+		t.Errorf("100 = 13 x 4 + 12 x 4: %d %d", opt.maxEntriesOfShard(0), opt.maxEntriesOfShard(7))
+	}
+
+	opt = newDefaultOption()
+	WithMaxEntries(100)(opt)
+	if opt.shardCount() != 8 {
+		t.Errorf("slab size reduced: %d", opt.shardCount())
+	}
+	total := 0
+	for i := 0; i < opt.shardCount(); i += 1 {
+		total += opt.maxEntriesOfShard(i)
+	}
+	if total != 100 {
+		t.Errorf("sum of shards is max entries: %d", total)
+	}
+
+	WithMaxEntriesPerShard(5)(opt)
+	if opt.maxEntriesOfShard(0) != 5 {
+		t.Errorf("per shard takes precedence: %d", opt.maxEntriesOfShard(0))
+	}
+	if opt.shardCount() != 1024 {
+		t.Errorf("slab size not reduced by per shard limit: %d", opt.shardCount())
+	}
+}
+
+func TestMaxBytesOption(t *testing.T) {
+	d := newDefaultOption()
+	if d.newByteBudget() != nil {
+		t.Errorf("default unbounded")
+	}
+	if _, ok := d.newCache(0, nil).(bytesCache); ok {
+		t.Errorf("default no bytes tracking")
+	}
+
+	opt := newDefaultOption()
+	WithSlabSize(8)(opt)
+	WithMaxBytes(1000)(opt)
+	b := opt.newByteBudget()
+	if b == nil || b.max != 1000 {
+		t.Errorf("whole limit shared by shards: %+v", b)
+	}
+	if _, ok := opt.newCache(0, b).(bytesCache); ok != true {
+		t.Errorf("bytes tracked")
+	}
+
+	opt = newDefaultOption()
+	WithSizer(DefaultSizer)(opt)
+	if opt.newByteBudget() != nil {
+		t.Errorf("no budget without limit")
+	}
+	if _, ok := opt.newCache(0, nil).(bytesCache); ok != true {
+		t.Errorf("bytes tracked by sizer without limit")
+	}
+
+	opt = newDefaultOption()
+	WithMaxBytes(1000)(opt)
+	WithCacheFactory(func(capacity int) Cache { return NewDefaultCache(capacity) })(opt)
+	if opt.newByteBudget() != nil {
+		t.Errorf("custom cache not measured")
+	}
+}
+
+func TestNameOption(t *testing.T) {
+	if New().Name() != "" {
+		t.Errorf("default no name")
+	}
+	if c := New(WithName("foo")); c.Name() != "foo" {
+		t.Errorf("name = foo: %s", c.Name())
+	}
 }
@@ -0,0 +1,316 @@
+package cmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+// Snapshot file format (version 1).
+// integers are unsigned varint unless noted, checksums are CRC-32 (Castagnoli) in big endian.
+//
+//	header:
+//	  magic        "CMAP" (4 bytes)
+//	  version      1 byte
+//	  codec name   length + bytes
+//	  shard count
+//	  checksum     crc32 of the header fields above (4 bytes)
+//	block (repeated shard count times):
+//	  payload length
+//	  payload:
+//	    entry count
+//	    entry (repeated entry count times):
+//	      key        length + bytes
+//	      expire at  unix nano of expiration, 0 = no expiration
+//	      value      length + codec encoded bytes
+//	  checksum     crc32 of payload (4 bytes)
+const (
+	snapshotMagic        = "CMAP"
+	snapshotVersion byte = 1
+
+	// block and its checksum fit in int of 32-bit platforms
+	maxSnapshotBlockSize = math.MaxInt32 - 4
+
+	readChunkSize = 64 * 1024
+)
+
+var (
+	ErrSnapshotFormat   = errors.New("cmap: invalid snapshot format")
+	ErrSnapshotVersion  = errors.New("cmap: unsupported snapshot version")
+	ErrSnapshotChecksum = errors.New("cmap: snapshot checksum mismatch")
+	ErrSnapshotCodec    = errors.New("cmap: snapshot codec mismatch")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type snapshotEntry struct {
+	key      string
+	value    interface{}
+	expireAt int64
+}
+
+// SaveTo writes all entries to w, shard by shard.
+// each shard is copied under its read lock and encoded outside of the lock.
+func (c *CMap) SaveTo(w io.Writer) error {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	bw := bufio.NewWriter(w)
+	shards := s.Shards()
+	if err := writeSnapshotHeader(bw, c.opt.codec.Name(), len(shards)); err != nil {
+		return err
+	}
+
+	entries := make([]snapshotEntry, 0, 64)
+	payload := bytes.NewBuffer(nil)
+	for _, m := range shards {
+		entries = copyShardEntries(m, entries[:0])
+
+		payload.Reset()
+		if err := encodeSnapshotBlock(payload, c.opt.codec, entries); err != nil {
+			return err
+		}
+		if err := writeSnapshotBlock(bw, payload.Bytes()); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func copyShardEntries(m *shard, entries []snapshotEntry) []snapshotEntry {
+	m.RLock()
+	defer m.RUnlock()
+
+	now := time.Now()
+	tc, hasTTL := m.Cache.(ttlCache)
+	m.Range(func(key string, value interface{}) bool {
+		expireAt := int64(0)
+		if hasTTL {
+			if ttl, ok := tc.TTL(key); ok {
+				expireAt = now.Add(ttl).UnixNano()
+			}
+		}
+		entries = append(entries, snapshotEntry{key, value, expireAt})
+		return true
+	})
+	return entries
+}
+
+func writeSnapshotHeader(w io.Writer, codecName string, shardCount int) error {
+	header := make([]byte, 0, 64)
+	header = append(header, snapshotMagic...)
+	header = append(header, snapshotVersion)
+	header = appendUvarint(header, uint64(len(codecName)))
+	header = append(header, codecName...)
+	header = appendUvarint(header, uint64(shardCount))
+	header = appendUint32(header, crc32.Checksum(header, crcTable))
+	_, err := w.Write(header)
+	return err
+}
+
+func encodeSnapshotBlock(buf *bytes.Buffer, codec Codec, entries []snapshotEntry) error {
+	b := make([]byte, 0, binary.MaxVarintLen64)
+	buf.Write(appendUvarint(b, uint64(len(entries))))
+	for _, e := range entries {
+		data, err := codec.Marshal(e.value)
+		if err != nil {
+			return fmt.Errorf("cmap: encode value of key %s: %w", e.key, err)
+		}
+		buf.Write(appendUvarint(b, uint64(len(e.key))))
+		buf.WriteString(e.key)
+		buf.Write(appendUvarint(b, uint64(e.expireAt)))
+		buf.Write(appendUvarint(b, uint64(len(data))))
+		buf.Write(data)
+	}
+	return nil
+}
+
+func writeSnapshotBlock(w io.Writer, payload []byte) error {
+	if maxSnapshotBlockSize < len(payload) {
+		return fmt.Errorf("%w: block of %d bytes exceeds %d", ErrSnapshotFormat, len(payload), maxSnapshotBlockSize)
+	}
+	b := make([]byte, 0, binary.MaxVarintLen64)
+	if _, err := w.Write(appendUvarint(b, uint64(len(payload)))); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	_, err := w.Write(appendUint32(b, crc32.Checksum(payload, crcTable)))
+	return err
+}
+
+// LoadFrom reads entries written by SaveTo and sets them into the map, shard block by shard block.
+// existing entries with same key are replaced, and entries already expired are skipped.
+// entries keep their expiry as saved, WithDefaultTTL is not applied to entries without expiry.
+// when an error is returned, blocks read before the error remain loaded.
+func (c *CMap) LoadFrom(r io.Reader) error {
+	br := bufio.NewReader(r)
+	shardCount, err := readSnapshotHeader(br, c.opt.codec.Name())
+	if err != nil {
+		return err
+	}
+
+	entries := make([]snapshotEntry, 0, 64)
+	for i := uint64(0); i < shardCount; i += 1 {
+		payload, err := readSnapshotBlock(br)
+		if err != nil {
+			return err
+		}
+		entries, err = decodeSnapshotBlock(payload, c.opt.codec, entries[:0])
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UnixNano()
+		for _, e := range entries {
+			if e.expireAt == 0 || now < e.expireAt {
+				c.restore(e.key, e.value, e.expireAt)
+			}
+		}
+	}
+	return nil
+}
+
+func readSnapshotHeader(r *bufio.Reader, codecName string) (uint64, error) {
+	header := make([]byte, 0, 64)
+
+	magic := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return 0, err
+	}
+	if string(magic[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, ErrSnapshotFormat
+	}
+	if magic[len(snapshotMagic)] != snapshotVersion {
+		return 0, fmt.Errorf("%w: %d", ErrSnapshotVersion, magic[len(snapshotMagic)])
+	}
+	header = append(header, magic...)
+
+	nameLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if 0xff < nameLen {
+		return 0, ErrSnapshotFormat
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return 0, err
+	}
+	shardCount, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	header = appendUvarint(header, nameLen)
+	header = append(header, name...)
+	header = appendUvarint(header, shardCount)
+
+	sum := make([]byte, 4)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return 0, err
+	}
+	if binary.BigEndian.Uint32(sum) != crc32.Checksum(header, crcTable) {
+		return 0, ErrSnapshotChecksum
+	}
+	if string(name) != codecName {
+		return 0, fmt.Errorf("%w: %s != %s", ErrSnapshotCodec, name, codecName)
+	}
+	return shardCount, nil
+}
+
+func readSnapshotBlock(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if maxSnapshotBlockSize < size {
+		return nil, ErrSnapshotFormat
+	}
+	payload, err := readChunked(r, int(size)+4)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	sum := binary.BigEndian.Uint32(payload[size:])
+	payload = payload[:size]
+	if sum != crc32.Checksum(payload, crcTable) {
+		return nil, ErrSnapshotChecksum
+	}
+	return payload, nil
+}
+
+// readChunked reads size bytes, buffer grows as data arrives so that corrupt size does not allocate at once
+func readChunked(r io.Reader, size int) ([]byte, error) {
+	if size <= readChunkSize {
+		b := make([]byte, size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, readChunkSize))
+	if n, err := io.CopyN(buf, r, int64(size)); err != nil {
+		if err == io.EOF && 0 < n {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func decodeSnapshotBlock(payload []byte, codec Codec, entries []snapshotEntry) ([]snapshotEntry, error) {
+	r := bytes.NewReader(payload)
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrSnapshotFormat
+	}
+	for i := uint64(0); i < count; i += 1 {
+		key, err := readSnapshotBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		expireAt, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrSnapshotFormat
+		}
+		data, err := readSnapshotBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := codec.Unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("cmap: decode value of key %s: %w", key, err)
+		}
+		entries = append(entries, snapshotEntry{string(key), value, int64(expireAt)})
+	}
+	if r.Len() != 0 {
+		return nil, ErrSnapshotFormat
+	}
+	return entries, nil
+}
+
+func readSnapshotBytes(r *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrSnapshotFormat
+	}
+	if uint64(r.Len()) < size {
+		return nil, ErrSnapshotFormat
+	}
+	b := make([]byte, size)
+	r.Read(b)
+	return b, nil
+}
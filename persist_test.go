@@ -0,0 +1,196 @@
+package cmap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSaveToLoadFrom(t *testing.T) {
+	src := New(WithSlabSize(8))
+	for i := 0; i < 1000; i += 1 {
+		src.Set(strconv.Itoa(i), i)
+	}
+	src.Set("struct", codecTestValue{"foo", 1})
+
+	buf := bytes.NewBuffer(nil)
+	if err := src.SaveTo(buf); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	dst := New(WithSlabSize(32))
+	dst.Set("foo", "bar")
+	if err := dst.LoadFrom(buf); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if dst.Len() != 1002 {
+		t.Errorf("loaded 1001 keys + existing key: %d", dst.Len())
+	}
+	for i := 0; i < 1000; i += 1 {
+		if v, ok := dst.Get(strconv.Itoa(i)); ok != true || v != i {
+			t.Errorf("key %d = %d: %v", i, i, v)
+		}
+	}
+	if v, _ := dst.Get("struct"); v != (codecTestValue{"foo", 1}) {
+		t.Errorf("registered type round trip: %v", v)
+	}
+}
+
+func TestSaveToLoadFromTTL(t *testing.T) {
+	src := New(WithSlabSize(4))
+	src.Set("forever", 1)
+	src.SetWithTTL("long", 2, time.Hour)
+	src.SetWithTTL("short", 3, 50*time.Millisecond)
+
+	buf := bytes.NewBuffer(nil)
+	if err := src.SaveTo(buf); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	dst := New(WithSlabSize(4))
+	if err := dst.LoadFrom(buf); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if _, ok := dst.Get("forever"); ok != true {
+		t.Errorf("no ttl key loaded")
+	}
+	if _, ok := dst.Get("short"); ok {
+		t.Errorf("expired key skipped")
+	}
+
+	m := dst.rlockShard("long")
+	ttl, ok := m.Cache.(ttlCache).TTL("long")
+	m.RUnlock()
+	if ok != true || ttl <= 0 || time.Hour < ttl {
+		t.Errorf("remaining ttl kept: %s", ttl)
+	}
+
+	buf.Reset()
+	if err := src.SaveTo(buf); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	def := New(WithDefaultTTL(time.Minute))
+	if err := def.LoadFrom(buf); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	m = def.rlockShard("forever")
+	_, ok = m.Cache.(ttlCache).TTL("forever")
+	m.RUnlock()
+	if ok {
+		t.Errorf("no expiry kept without default ttl")
+	}
+}
+
+func TestLoadFromError(t *testing.T) {
+	src := New(WithSlabSize(2))
+	src.Set("foo", "bar")
+	src.Set("hello", "world")
+	buf := bytes.NewBuffer(nil)
+	if err := src.SaveTo(buf); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	data := buf.Bytes()
+
+	t.Run("magic", func(tt *testing.T) {
+		b := append([]byte("XMAP"), data[4:]...)
+		if err := New().LoadFrom(bytes.NewReader(b)); errors.Is(err, ErrSnapshotFormat) != true {
+			tt.Errorf("invalid magic: %v", err)
+		}
+	})
+	t.Run("version", func(tt *testing.T) {
+		b := append([]byte(nil), data...)
+		b[4] = 99
+		if err := New().LoadFrom(bytes.NewReader(b)); errors.Is(err, ErrSnapshotVersion) != true {
+			tt.Errorf("unsupported version: %v", err)
+		}
+	})
+	t.Run("checksum", func(tt *testing.T) {
+		b := append([]byte(nil), data...)
+		b[len(b)-5] ^= 0xff
+		if err := New().LoadFrom(bytes.NewReader(b)); errors.Is(err, ErrSnapshotChecksum) != true {
+			tt.Errorf("corrupted block: %v", err)
+		}
+	})
+	t.Run("truncated", func(tt *testing.T) {
+		if err := New().LoadFrom(bytes.NewReader(data[:len(data)-2])); errors.Is(err, io.ErrUnexpectedEOF) != true {
+			tt.Errorf("truncated: %v", err)
+		}
+	})
+	t.Run("length", func(tt *testing.T) {
+		headerSize := len(snapshotMagic) + 1 + 1 + len(NewGobCodec().Name()) + 1 + 4
+		b := appendUvarint(append([]byte(nil), data[:headerSize]...), maxSnapshotBlockSize)
+		b = append(b, "corrupt"...)
+
+		c := New()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if err := c.LoadFrom(bytes.NewReader(b)); errors.Is(err, io.ErrUnexpectedEOF) != true {
+			tt.Errorf("corrupt length: %v", err)
+		}
+		runtime.ReadMemStats(&after)
+		if 1<<20 < after.TotalAlloc-before.TotalAlloc {
+			tt.Errorf("allocated by corrupt length: %d", after.TotalAlloc-before.TotalAlloc)
+		}
+
+		b = appendUvarint(append([]byte(nil), data[:headerSize]...), maxSnapshotBlockSize+1)
+		if err := New().LoadFrom(bytes.NewReader(b)); errors.Is(err, ErrSnapshotFormat) != true {
+			tt.Errorf("too large length: %v", err)
+		}
+	})
+	t.Run("codec", func(tt *testing.T) {
+		if err := New(WithCodec(otherCodec{})).LoadFrom(bytes.NewReader(data)); errors.Is(err, ErrSnapshotCodec) != true {
+			tt.Errorf("codec mismatch: %v", err)
+		}
+	})
+}
+
+type otherCodec struct {
+	gobCodec
+}
+
+func (otherCodec) Name() string {
+	return "other"
+}
+
+func BenchmarkSaveTo(b *testing.B) {
+	c := New()
+	for i := 0; i < 100000; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		if err := c.SaveTo(io.Discard); err != nil {
+			b.Fatalf("no error: %+v", err)
+		}
+	}
+}
+
+func TestSaveToLoadFromCodec(t *testing.T) {
+	for _, codec := range []Codec{NewGobCodec(), NewJSONCodec(), NewRawCodec()} {
+		t.Run(codec.Name(), func(tt *testing.T) {
+			src := New(WithCodec(codec))
+			src.Set("foo", "bar")
+			src.Set("bytes", []byte("baz"))
+
+			buf := bytes.NewBuffer(nil)
+			if err := src.SaveTo(buf); err != nil {
+				tt.Fatalf("no error: %+v", err)
+			}
+			dst := New(WithCodec(codec))
+			if err := dst.LoadFrom(buf); err != nil {
+				tt.Fatalf("no error: %+v", err)
+			}
+			if v, _ := dst.Get("foo"); v != "bar" {
+				tt.Errorf("foo = bar: %v", v)
+			}
+			if v, _ := dst.Get("bytes"); string(v.([]byte)) != "baz" {
+				tt.Errorf("bytes = baz: %v", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package cmap
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache is implemented by Cache that can report remaining TTL of entry
+type ttlCache interface {
+	TTL(key string) (time.Duration, bool)
+}
+
+// expiryCache is implemented by built-in Cache, iterates stored entries including expired ones.
+// expireAt is absolute time in unix nano, 0 for entry without TTL.
+type expiryCache interface {
+	rangeExpiry(fn func(key string, value interface{}, expireAt int64) bool)
+}
+
+// resizeGate allows multi-shard operations to run concurrently, and excludes them from resize.
+// it prefers entered operations over pending resize, so re-entering from callbacks never blocks.
+type resizeGate struct {
+	mutex    *sync.Mutex
+	cond     *sync.Cond
+	entered  int
+	resizing bool
+}
+
+func (g *resizeGate) enter() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for g.resizing {
+		g.cond.Wait()
+	}
+	g.entered += 1
+}
+
+func (g *resizeGate) leave() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.entered -= 1
+	if g.entered == 0 {
+		g.cond.Broadcast()
+	}
+}
+
+func (g *resizeGate) beginResize() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for g.resizing || 0 < g.entered {
+		g.cond.Wait()
+	}
+	g.resizing = true
+}
+
+func (g *resizeGate) endResize() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.resizing = false
+	g.cond.Broadcast()
+}
+
+func newResizeGate() *resizeGate {
+	g := &resizeGate{mutex: new(sync.Mutex)}
+	g.cond = sync.NewCond(g.mutex)
+	return g
+}
+
+func (c *CMap) loadSlab() *slab {
+	return c.current.Load().(*slab)
+}
+
+// enterSlab returns slab that is not resized until leaveSlab
+func (c *CMap) enterSlab() *slab {
+	c.gate.enter()
+	return c.loadSlab()
+}
+
+func (c *CMap) leaveSlab() {
+	c.gate.leave()
+}
+
+// lockShard locks the shard of key, follows to next slab if the shard has been migrated
+func (c *CMap) lockShard(key string) *shard {
+	s := c.loadSlab()
+	for {
+		m := s.GetShard(key)
+		m.Lock()
+		if m.moved != true {
+			return m
+		}
+		m.Unlock()
+		s = s.next
+	}
+}
+
+func (c *CMap) rlockShard(key string) *shard {
+	s := c.loadSlab()
+	for {
+		m := s.GetShard(key)
+		m.RLock()
+		if m.moved != true {
+			return m
+		}
+		m.RUnlock()
+		s = s.next
+	}
+}
+
+func (c *CMap) SlabSize() int {
+	return len(c.loadSlab().Shards())
+}
+
+// Resize changes number of shards online.
+// entries are migrated shard by shard, single key operations continue during migration
+// and multi-shard operations (Len, Range, Snapshot, Tx, ...) wait until it completes.
+// OnEvict of entries evicted by migration is called after resize completes.
+// slab size of map bounded by WithMaxEntries is limited, see WithMaxEntries.
+// Resize must not be called from callbacks.
+func (c *CMap) Resize(size int) {
+	if size < 1 {
+		return
+	}
+
+	for _, e := range c.resize(size) {
+		c.onEvict(e.key, e.value, e.reason)
+	}
+}
+
+func (c *CMap) resize(size int) []evictedEntry {
+	c.gate.beginResize()
+	defer c.gate.endResize()
+
+	opt := *c.opt
+	opt.slabSize = size
+
+	old := c.loadSlab()
+	if len(old.Shards()) == opt.shardCount() {
+		return nil
+	}
+
+	next := newSlabWithHooks(&opt, c.watch, c.wal)
+	old.next = next
+
+	evicted := make([]evictedEntry, 0)
+	for _, m := range old.Shards() {
+		evicted = append(evicted, c.migrateShard(m, next)...)
+	}
+	c.current.Store(next)
+
+	for _, m := range old.Shards() {
+		c.statsBase = c.statsBase.add(m.stats.load())
+	}
+	return evicted
+}
+
+type migrateEntry struct {
+	key      string
+	value    interface{}
+	expireAt int64
+}
+
+// migrateShard moves entries of m to next, returns entries evicted while migration.
+// entries are inserted from the end of Range, so that LRU keeps recency order.
+// TTL is kept as absolute expiry, entries expired until inserted are evicted as expired.
+func (c *CMap) migrateShard(m *shard, next *slab) []evictedEntry {
+	m.Lock()
+	defer m.Unlock()
+
+	entries := make([]migrateEntry, 0, m.Cache.Len())
+	collect := func(key string, value interface{}, expireAt int64) bool {
+		entries = append(entries, migrateEntry{key, value, expireAt})
+		return true
+	}
+	if ec, ok := m.Cache.(expiryCache); ok {
+		ec.rangeExpiry(collect)
+	} else {
+		// Range of custom Cache may hide expired entries, remove them first
+		m.RemoveExpired()
+		now := time.Now()
+		tc, isTTLCache := m.Cache.(ttlCache)
+		m.Range(func(key string, value interface{}) bool {
+			expireAt := int64(0)
+			if isTTLCache {
+				if ttl, ok := tc.TTL(key); ok {
+					expireAt = now.Add(ttl).UnixNano()
+				}
+			}
+			return collect(key, value, expireAt)
+		})
+	}
+
+	evicted := make([]evictedEntry, 0)
+	for i := len(entries) - 1; 0 <= i; i -= 1 {
+		e := entries[i]
+		ttl := time.Duration(0)
+		if e.expireAt != 0 {
+			if ttl = time.Duration(e.expireAt - time.Now().UnixNano()); ttl <= 0 {
+				m.recordEvicted(e.key, e.value, EvictReasonExpired)
+				continue
+			}
+		}
+		evicted = append(evicted, insertMigrated(next.GetShard(e.key), e.key, e.value, ttl)...)
+	}
+	m.moved = true
+	return append(m.takeEvicted(), evicted...)
+}
+
+func insertMigrated(nm *shard, key string, value interface{}, ttl time.Duration) []evictedEntry {
+	nm.Lock()
+	defer nm.Unlock()
+
+	// bypass shard to not emit watch events
+	if 0 < ttl {
+		nm.Cache.SetWithTTL(key, value, ttl)
+	} else {
+		nm.Cache.Set(key, value)
+	}
+	return nm.takeEvicted()
+}
+
+func (c *CMap) runAutoResize(interval time.Duration, maxAvgShardLen int) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			size := c.SlabSize()
+			if c.opt.shardCountOf(size*2) == size {
+				// pinned by WithMaxEntries, resize would take the gate for nothing
+				return
+			}
+			if maxAvgShardLen < c.Len()/size {
+				c.Resize(size * 2)
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCmapResize(t *testing.T) {
+	t.Run("grow/shrink", func(tt *testing.T) {
+		c := New(WithSlabSize(4))
+		for i := 0; i < 1000; i += 1 {
+			c.Set(strconv.Itoa(i), i)
+		}
+
+		for size, expect := range map[int]int{64: 64, 3: 4, 100: 128} {
+			c.Resize(size)
+			if c.SlabSize() != expect {
+				tt.Errorf("slab size %d: %d", expect, c.SlabSize())
+			}
+			if c.Len() != 1000 {
+				tt.Errorf("entries migrated: %d", c.Len())
+			}
+			for i := 0; i < 1000; i += 1 {
+				if v, ok := c.Get(strconv.Itoa(i)); ok != true || v.(int) != i {
+					tt.Errorf("key %d migrated: %v", i, v)
+				}
+			}
+		}
+
+		size := c.SlabSize()
+		c.Resize(0)
+		if c.SlabSize() != size {
+			tt.Errorf("invalid size ignored")
+		}
+	})
+	t.Run("ttl", func(tt *testing.T) {
+		c := New(WithSlabSize(2))
+		c.SetWithTTL("foo", "bar", 30*time.Millisecond)
+		c.Set("hello", "world")
+
+		c.Resize(16)
+		if _, ok := c.Get("foo"); ok != true {
+			tt.Errorf("foo migrated")
+		}
+		time.Sleep(40 * time.Millisecond)
+		if _, ok := c.Get("foo"); ok {
+			tt.Errorf("ttl migrated")
+		}
+		if _, ok := c.Get("hello"); ok != true {
+			tt.Errorf("no ttl entry migrated")
+		}
+	})
+	t.Run("ttl/expiring", func(tt *testing.T) {
+		expired := int32(0)
+		c := New(WithSlabSize(1), WithOnEvict(func(key string, value interface{}, reason EvictReason) {
+			if reason == EvictReasonExpired {
+				atomic.AddInt32(&expired, 1)
+			}
+		}))
+		for i := 0; i < 20000; i += 1 {
+			c.SetWithTTL(strconv.Itoa(i), i, time.Duration(10+i%20)*time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+		c.Resize(4)
+		time.Sleep(30 * time.Millisecond)
+
+		for i := 0; i < 20000; i += 1 {
+			if _, ok := c.Get(strconv.Itoa(i)); ok {
+				tt.Fatalf("key %d expires after migration", i)
+			}
+		}
+		c.RemoveExpired()
+		if c.Len() != 0 {
+			tt.Errorf("all entries expired: %d", c.Len())
+		}
+		if n := atomic.LoadInt32(&expired); n != 20000 {
+			tt.Errorf("every entry evicted as expired once: %d", n)
+		}
+	})
+	t.Run("onevict/reentrant", func(tt *testing.T) {
+		var c *CMap
+		lens := make([]int, 0)
+		c = New(WithSlabSize(2), WithOnEvict(func(key string, value interface{}, reason EvictReason) {
+			// multi-shard operation must not deadlock
+			lens = append(lens, c.Len())
+		}))
+		c.SetWithTTL("foo", "bar", 10*time.Millisecond)
+		c.Set("hello", "world")
+		time.Sleep(20 * time.Millisecond)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			c.Resize(16)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			tt.Fatalf("deadlock in OnEvict")
+		}
+		if len(lens) != 1 || lens[0] != 1 {
+			tt.Errorf("expired foo evicted after resize: %v", lens)
+		}
+	})
+	t.Run("lru order", func(tt *testing.T) {
+		c := New(WithSlabSize(1), WithMaxEntriesPerShard(100))
+		for i := 0; i < 50; i += 1 {
+			c.Set(strconv.Itoa(i), i)
+		}
+		c.Resize(4)
+		for _, m := range c.loadSlab().Shards() {
+			prev := 50
+			for _, key := range m.Keys() {
+				i, _ := strconv.Atoi(key)
+				if prev < i {
+					tt.Errorf("most recently used first: %d after %d", i, prev)
+				}
+				prev = i
+			}
+		}
+	})
+	t.Run("nowatch", func(tt *testing.T) {
+		c := New(WithSlabSize(2))
+		c.Set("foo", "bar")
+		s := c.Watch("foo")
+		defer s.Close()
+
+		c.Resize(8)
+		select {
+		case ev := <-s.Events():
+			tt.Errorf("migration is not a change: %+v", ev)
+		default:
+		}
+	})
+	t.Run("concurrent", func(tt *testing.T) {
+		c := New(WithSlabSize(2))
+		writers := 8
+		count := 2000
+
+		wg := new(sync.WaitGroup)
+		for i := 0; i < writers; i += 1 {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+
+				prefix := strconv.Itoa(n) + "/"
+				for j := 0; j < count; j += 1 {
+					key := prefix + strconv.Itoa(j%100)
+					c.Upsert(key, func(exists bool, v interface{}) interface{} {
+						if exists {
+							return v.(int) + 1
+						}
+						return 1
+					})
+					c.Get(key)
+				}
+			}(i)
+		}
+		for _, size := range []int{8, 32, 128, 16} {
+			c.Resize(size)
+		}
+		wg.Wait()
+
+		if c.Len() != writers*100 {
+			tt.Errorf("no entries lost: %d", c.Len())
+		}
+		sum := 0
+		c.Range(func(key string, value interface{}) bool {
+			sum += value.(int)
+			return true
+		})
+		if sum != writers*count {
+			tt.Errorf("no writes lost: %d", sum)
+		}
+	})
+}
+
+func TestCmapAutoResize(t *testing.T) {
+	c := New(WithSlabSize(2), WithAutoResize(5*time.Millisecond, 10))
+	defer c.Close()
+
+	for i := 0; i < 1000; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if c.SlabSize() < 128 {
+		t.Errorf("grows until average shard length <= 10: %d", c.SlabSize())
+	}
+	if c.Len() != 1000 {
+		t.Errorf("entries migrated: %d", c.Len())
+	}
+}
+
+func TestCmapAutoResizeMaxEntries(t *testing.T) {
+	c := New(WithSlabSize(8), WithMaxEntries(64))
+	defer c.Close()
+
+	c.Resize(16)
+	if c.SlabSize() != 8 {
+		t.Errorf("limited by max entries: %d", c.SlabSize())
+	}
+
+	done := make(chan struct{})
+	c.wg.Add(1)
+	go func() {
+		c.runAutoResize(time.Millisecond, 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("auto resize stops when slab size is pinned")
+	}
+}
+
+func TestResizeGate(t *testing.T) {
+	g := newResizeGate()
+	g.enter()
+
+	resized := make(chan struct{})
+	go func() {
+		g.beginResize()
+		close(resized)
+		g.endResize()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// re-enter while resize is pending
+	g.enter()
+	g.leave()
+
+	select {
+	case <-resized:
+		t.Errorf("resize waits entered operation")
+	default:
+	}
+
+	g.leave()
+	select {
+	case <-resized:
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("resize started")
+	}
+}
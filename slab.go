@@ -1,29 +1,257 @@
 package cmap
 
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type tryLocker interface {
+	TryLock() bool
+	TryRLock() bool
+}
+
+type shard struct {
+	Cache
+	evicted []evictedEntry
+	notify  bool
+	setting interface{}
+	watch   *watchHub
+	wal     *writeAheadLog
+	batch   *walBatch
+	budget  *byteBudget
+	moved   bool
+	locker  tryLocker
+	stats   shardStats
+}
+
+func (s *shard) Lock() {
+	if s.locker == nil {
+		s.Cache.Lock()
+		return
+	}
+	if s.locker.TryLock() {
+		return
+	}
+	start := time.Now()
+	s.Cache.Lock()
+	s.stats.contended(time.Since(start))
+}
+
+func (s *shard) RLock() {
+	if s.locker == nil {
+		s.Cache.RLock()
+		return
+	}
+	if s.locker.TryRLock() {
+		return
+	}
+	start := time.Now()
+	s.Cache.RLock()
+	s.stats.contended(time.Since(start))
+}
+
+// lookup is Get that counts hit or miss, used by reads of caller not by internal reads of updates
+func (s *shard) lookup(key string) (interface{}, bool) {
+	v, ok := s.Cache.Get(key)
+	if ok {
+		atomic.AddUint64(&s.stats.hits, 1)
+	} else {
+		atomic.AddUint64(&s.stats.misses, 1)
+	}
+	return v, ok
+}
+
+func (s *shard) Set(key string, value interface{}) {
+	atomic.AddUint64(&s.stats.sets, 1)
+	s.setting = value
+	if s.watch.active() {
+		old, ok := s.Cache.Get(key)
+		s.Cache.Set(key, value)
+		s.emitSet(key, old, ok, value)
+	} else {
+		s.Cache.Set(key, value)
+	}
+	s.setting = nil
+	if s.wal != nil {
+		s.wal.appendSet(key, value, 0, s.batch)
+	}
+}
+
+func (s *shard) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	atomic.AddUint64(&s.stats.sets, 1)
+	s.setting = value
+	if s.watch.active() {
+		old, ok := s.Cache.Get(key)
+		s.Cache.SetWithTTL(key, value, ttl)
+		s.emitSet(key, old, ok, value)
+	} else {
+		s.Cache.SetWithTTL(key, value, ttl)
+	}
+	s.setting = nil
+	if s.wal != nil {
+		expireAt := int64(0)
+		if 0 < ttl {
+			expireAt = time.Now().Add(ttl).UnixNano()
+		}
+		s.wal.appendSet(key, value, expireAt, s.batch)
+	}
+}
+
+func (s *shard) Remove(key string) (interface{}, bool) {
+	v, ok := s.Cache.Remove(key)
+	if ok != true {
+		return v, ok
+	}
+	atomic.AddUint64(&s.stats.removes, 1)
+	if s.wal != nil {
+		s.wal.appendRemove(key, s.batch)
+	}
+	if s.watch.active() {
+		s.watch.emit(Event{Type: EventDelete, Key: key, OldValue: v})
+	}
+	return v, ok
+}
+
+// Range iterates entries by rangeCache, otherwise by Keys and Get
+func (s *shard) Range(fn RangeFunc) {
+	if rc, ok := s.Cache.(rangeCache); ok {
+		rc.Range(fn)
+		return
+	}
+	for _, key := range s.Cache.Keys() {
+		value, ok := s.Cache.Get(key)
+		if ok != true {
+			continue
+		}
+		if fn(key, value) != true {
+			return
+		}
+	}
+}
+
+func (s *shard) emitSet(key string, old interface{}, exists bool, value interface{}) {
+	if exists {
+		s.watch.emit(Event{Type: EventUpdate, Key: key, OldValue: old, NewValue: value})
+	} else {
+		s.watch.emit(Event{Type: EventSet, Key: key, NewValue: value})
+	}
+}
+
+func (s *shard) countEvicted(reason EvictReason) {
+	switch reason {
+	case EvictReasonExpired, EvictReasonCapacity:
+		atomic.AddUint64(&s.stats.evictions, 1)
+	}
+}
+
+func (s *shard) recordEvicted(key string, value interface{}, reason EvictReason) {
+	s.countEvicted(reason)
+	if s.notify != true {
+		return
+	}
+	if reason == EvictReasonReplaced && sameValue(value, s.setting) {
+		return
+	}
+	s.evicted = append(s.evicted, evictedEntry{key, value, reason})
+}
+
+// takeEvicted returns entries evicted while holding the lock
+func (s *shard) takeEvicted() []evictedEntry {
+	if len(s.evicted) < 1 {
+		return nil
+	}
+	evicted := s.evicted
+	s.evicted = nil
+	return evicted
+}
+
+func newShard(cache Cache, opt *cmapOption, watch *watchHub, wal *writeAheadLog) *shard {
+	s := &shard{Cache: cache, watch: watch, wal: wal, notify: opt.onEvict != nil}
+	if locker, ok := cache.(tryLocker); ok {
+		s.locker = locker
+	}
+	if rc, ok := cache.(reasonCache); ok && s.notify != true {
+		rc.SetOnEvictReason(s.countEvicted)
+	} else {
+		cache.SetOnEvict(s.recordEvicted)
+	}
+	return s
+}
+
 type slab struct {
-	shards []Cache
-	size   uint64
+	shards []*shard
+	mask   uint64
 	hash   CMapHashFunc
+	next   *slab
 }
 
 func newSlab(opt *cmapOption) *slab {
-	shards := make([]Cache, opt.slabSize)
-	size64 := uint64(opt.slabSize)
-	for i := 0; i < opt.slabSize; i += 1 {
-		shards[i] = newDefaultCache(opt.cacheCapacity)
+	return newSlabWithHooks(opt, newWatchHub(opt.watchBufferSize, opt.watchDropPolicy), nil)
+}
+
+func newSlabWithHooks(opt *cmapOption, watch *watchHub, wal *writeAheadLog) *slab {
+	size := opt.shardCount()
+	shards := make([]*shard, size)
+	budget := opt.newByteBudget()
+	for i := 0; i < size; i += 1 {
+		shards[i] = newShard(opt.newCache(i, budget), opt, watch, wal)
+		shards[i].budget = budget
+	}
+	if budget != nil {
+		budget.shards = shards
 	}
 	return &slab{
 		shards: shards,
-		size:   size64,
+		mask:   uint64(size - 1),
 		hash:   opt.hashFunc,
 	}
 }
 
-func (s *slab) GetShard(key string) Cache {
-	idx := int(s.hash.Hash64(key) % s.size)
-	return s.shards[idx]
+func (s *slab) index(key string) int {
+	return int(mixShard(s.hash.Hash64(key)) & s.mask)
+}
+
+func (s *slab) GetShard(key string) *shard {
+	return s.shards[s.index(key)]
+}
+
+type uint64Slice []uint64
+
+func (p uint64Slice) Len() int           { return len(p) }
+func (p uint64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type shardGroup struct {
+	index     int
+	positions []int
+}
+
+// groupByShard returns positions of keys grouped by shard, in shard index order
+func (s *slab) groupByShard(keys []string) []shardGroup {
+	packed := make([]uint64, len(keys))
+	for i, key := range keys {
+		packed[i] = uint64(s.index(key))<<32 | uint64(i)
+	}
+	sort.Sort(uint64Slice(packed))
+
+	positions := make([]int, len(keys))
+	for i, p := range packed {
+		positions[i] = int(p & 0xffffffff)
+	}
+
+	groups := make([]shardGroup, 0, len(keys))
+	start := 0
+	for i := 1; i <= len(packed); i += 1 {
+		if i < len(packed) && packed[i]>>32 == packed[start]>>32 {
+			continue
+		}
+		groups = append(groups, shardGroup{int(packed[start] >> 32), positions[start:i]})
+		start = i
+	}
+	return groups
 }
 
-func (s *slab) Shards() []Cache {
+func (s *slab) Shards() []*shard {
 	return s.shards
 }
@@ -32,11 +32,11 @@ func TestSlabShard(t *testing.T) {
 			testRate(tt, r, expectRate, 0.05)
 		}
 	})
-	t.Run("conflict/50", func(tt *testing.T) {
+	t.Run("conflict/64", func(tt *testing.T) {
 		opt := newDefaultOption()
-		opt.slabSize = 50
+		opt.slabSize = 64
 
-		expectRate := 1.0 / 50.0
+		expectRate := 1.0 / 64.0
 
 		slab := newSlab(opt)
 		for i := 0; i < 1000; i += 1 {
@@ -49,3 +49,43 @@ func TestSlabShard(t *testing.T) {
 		}
 	})
 }
+
+func TestSlabShardFNV(t *testing.T) {
+	opt := newDefaultOption()
+	opt.slabSize = 16
+	opt.hashFunc = NewFNV64HashFun()
+
+	slab := newSlab(opt)
+	for i := 0; i < 1600; i += 1 {
+		key := strconv.Itoa(i)
+		slab.GetShard(key).Set(key, key)
+	}
+	for i, s := range slab.Shards() {
+		if n := s.Len(); n < 50 || 150 < n {
+			t.Errorf("shard[%d] = %d skewed", i, n)
+		}
+	}
+}
+
+func TestSlabPowerOfTwo(t *testing.T) {
+	for size, expect := range map[int]int{
+		0:    1,
+		1:    1,
+		2:    2,
+		3:    4,
+		50:   64,
+		1024: 1024,
+		1025: 2048,
+	} {
+		opt := newDefaultOption()
+		opt.slabSize = size
+
+		slab := newSlab(opt)
+		if len(slab.Shards()) != expect {
+			t.Errorf("slab size %d normalized to %d: %d", size, expect, len(slab.Shards()))
+		}
+		if slab.mask != uint64(expect-1) {
+			t.Errorf("mask = %d", slab.mask)
+		}
+	}
+}
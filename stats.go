@@ -0,0 +1,121 @@
+package cmap
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// bytesCache is implemented by Cache that tracks size of entries
+type bytesCache interface {
+	Bytes() int64
+}
+
+type shardStats struct {
+	hits        uint64
+	misses      uint64
+	sets        uint64
+	removes     uint64
+	evictions   uint64
+	contentions uint64
+	lockWait    int64
+}
+
+func (s *shardStats) contended(wait time.Duration) {
+	atomic.AddUint64(&s.contentions, 1)
+	atomic.AddInt64(&s.lockWait, int64(wait))
+}
+
+func (s *shardStats) load() ShardStats {
+	return ShardStats{
+		Hits:        atomic.LoadUint64(&s.hits),
+		Misses:      atomic.LoadUint64(&s.misses),
+		Sets:        atomic.LoadUint64(&s.sets),
+		Removes:     atomic.LoadUint64(&s.removes),
+		Evictions:   atomic.LoadUint64(&s.evictions),
+		Contentions: atomic.LoadUint64(&s.contentions),
+		LockWait:    time.Duration(atomic.LoadInt64(&s.lockWait)),
+	}
+}
+
+type ShardStats struct {
+	Len         int
+	Bytes       int64
+	Hits        uint64
+	Misses      uint64
+	Sets        uint64
+	Removes     uint64
+	Evictions   uint64
+	Contentions uint64
+	LockWait    time.Duration
+}
+
+func (s ShardStats) add(o ShardStats) ShardStats {
+	s.Len += o.Len
+	s.Bytes += o.Bytes
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Sets += o.Sets
+	s.Removes += o.Removes
+	s.Evictions += o.Evictions
+	s.Contentions += o.Contentions
+	s.LockWait += o.LockWait
+	return s
+}
+
+// Stats is summary of all shards.
+// counters are cumulative since New, including shards before Resize.
+type Stats struct {
+	ShardStats
+
+	Shards         []ShardStats
+	MaxShardLen    int
+	MinShardLen    int
+	MeanShardLen   float64
+	StddevShardLen float64
+}
+
+// Stats returns per-shard statistics.
+// lock wait and contentions are measured only for Cache that implements TryLock and TryRLock,
+// bytes are reported only for Cache that implements Bytes (WithMaxBytes or WithSizer).
+func (c *CMap) Stats() Stats {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	shards := s.Shards()
+	stats := Stats{
+		ShardStats: c.statsBase,
+		Shards:     make([]ShardStats, len(shards)),
+	}
+	for i, m := range shards {
+		ss := m.stats.load()
+		m.RLock()
+		ss.Len = m.Len()
+		if bc, ok := m.Cache.(bytesCache); ok {
+			ss.Bytes = bc.Bytes()
+		}
+		m.RUnlock()
+
+		stats.Shards[i] = ss
+		stats.ShardStats = stats.ShardStats.add(ss)
+	}
+
+	stats.MinShardLen = math.MaxInt
+	for _, ss := range stats.Shards {
+		if stats.MaxShardLen < ss.Len {
+			stats.MaxShardLen = ss.Len
+		}
+		if ss.Len < stats.MinShardLen {
+			stats.MinShardLen = ss.Len
+		}
+	}
+	stats.MeanShardLen = float64(stats.Len) / float64(len(shards))
+
+	variance := 0.0
+	for _, ss := range stats.Shards {
+		d := float64(ss.Len) - stats.MeanShardLen
+		variance += d * d
+	}
+	stats.StddevShardLen = math.Sqrt(variance / float64(len(shards)))
+	return stats
+}
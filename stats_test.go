@@ -0,0 +1,160 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCmapStats(t *testing.T) {
+	t.Run("counters", func(tt *testing.T) {
+		c := New(WithSlabSize(4), WithMaxEntriesPerShard(10))
+		for i := 0; i < 100; i += 1 {
+			c.Set(strconv.Itoa(i), i)
+		}
+		c.Get("99")
+		c.Get("notfound")
+		c.Remove("99")
+		c.Remove("notfound")
+
+		s := c.Stats()
+		if len(s.Shards) != 4 {
+			tt.Errorf("4 shards: %d", len(s.Shards))
+		}
+		if s.Len != c.Len() {
+			tt.Errorf("len = %d: %d", c.Len(), s.Len)
+		}
+		if s.Sets != 100 {
+			tt.Errorf("100 sets: %d", s.Sets)
+		}
+		if s.Hits != 1 || s.Misses != 1 {
+			tt.Errorf("1 hit 1 miss: %d %d", s.Hits, s.Misses)
+		}
+		if s.Removes != 1 {
+			tt.Errorf("1 remove: %d", s.Removes)
+		}
+		if s.Evictions != uint64(100-s.Len-1) {
+			tt.Errorf("capacity evictions: %d", s.Evictions)
+		}
+
+		total := 0
+		for _, ss := range s.Shards {
+			total += ss.Len
+		}
+		if total != s.Len {
+			tt.Errorf("sum of shards: %d", total)
+		}
+	})
+	t.Run("internal reads", func(tt *testing.T) {
+		c := New(WithSlabSize(4))
+		for i := 0; i < 10; i += 1 {
+			c.Upsert("a", func(exists bool, oldValue interface{}) interface{} {
+				return i
+			})
+		}
+		c.SetIf("b", func(exists bool, value interface{}) (interface{}, bool) {
+			return 1, true
+		})
+		c.CompareAndSwap("b", 1, 2)
+		c.LoadOrStore("c", 3)
+		c.Compute("d", func(oldValue interface{}, exists bool) (interface{}, ComputeOp) {
+			return 4, ComputeStore
+		})
+		c.Tx([]string{"a", "e"}, func(tx *Txn) error {
+			tx.Get("a")
+			tx.Get("e")
+			return nil
+		})
+		if s := c.Stats(); s.Hits != 0 || s.Misses != 0 {
+			tt.Errorf("internal reads not counted: %d %d", s.Hits, s.Misses)
+		}
+
+		c.GetRLocked("a", func(exists bool, value interface{}) interface{} {
+			return value
+		})
+		c.GetMany([]string{"b", "notfound"})
+		if s := c.Stats(); s.Hits != 2 || s.Misses != 1 {
+			tt.Errorf("2 hits 1 miss: %d %d", s.Hits, s.Misses)
+		}
+	})
+	t.Run("distribution", func(tt *testing.T) {
+		c := New(WithSlabSize(2))
+		c.Set("a", 1)
+		c.Set("b", 2)
+		c.Set("c", 3)
+		c.Set("d", 4)
+		c.Set("e", 5)
+		c.Set("f", 6)
+
+		s := c.Stats()
+		if s.MaxShardLen+s.MinShardLen != 6 {
+			tt.Errorf("max+min = 6: %d %d", s.MaxShardLen, s.MinShardLen)
+		}
+		if s.MeanShardLen != 3.0 {
+			tt.Errorf("mean = 3: %f", s.MeanShardLen)
+		}
+		expect := float64(s.MaxShardLen-s.MinShardLen) / 2
+		if s.StddevShardLen != expect {
+			tt.Errorf("stddev = %f: %f", expect, s.StddevShardLen)
+		}
+	})
+	t.Run("contention", func(tt *testing.T) {
+		c := New(WithSlabSize(1))
+		m := c.loadSlab().Shards()[0]
+
+		m.Lock()
+		wg := new(sync.WaitGroup)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Get("foo")
+		}()
+		time.Sleep(20 * time.Millisecond)
+		m.Unlock()
+		wg.Wait()
+
+		s := c.Stats()
+		if s.Contentions != 1 {
+			tt.Errorf("1 contention: %d", s.Contentions)
+		}
+		if s.LockWait < 10*time.Millisecond {
+			tt.Errorf("lock wait >= 10ms: %s", s.LockWait)
+		}
+	})
+	t.Run("bytes", func(tt *testing.T) {
+		c := New(WithSlabSize(4), WithMaxBytes(4*1024), WithSizer(func(key string, value interface{}) int64 {
+			return int64(len(value.([]byte)))
+		}))
+		for i := 0; i < 100; i += 1 {
+			c.Set(strconv.Itoa(i), make([]byte, 100))
+		}
+
+		s := c.Stats()
+		if s.Bytes != int64(s.Len*100) {
+			tt.Errorf("bytes = len * 100: %d %d", s.Bytes, s.Len)
+		}
+		if 4*1024 < s.Bytes {
+			tt.Errorf("total within budget: %d", s.Bytes)
+		}
+		if s.Evictions == 0 {
+			tt.Errorf("evicted over budget")
+		}
+	})
+	t.Run("resize", func(tt *testing.T) {
+		c := New(WithSlabSize(2))
+		for i := 0; i < 10; i += 1 {
+			c.Set(strconv.Itoa(i), i)
+		}
+		c.Resize(8)
+		c.Set("foo", "bar")
+
+		s := c.Stats()
+		if s.Sets != 11 {
+			tt.Errorf("counters are cumulative across resize: %d", s.Sets)
+		}
+		if len(s.Shards) != 8 {
+			tt.Errorf("8 shards: %d", len(s.Shards))
+		}
+	})
+}
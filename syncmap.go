@@ -0,0 +1,122 @@
+package cmap
+
+import (
+	"sync"
+)
+
+// SyncMapper is the method set of sync.Map
+type SyncMapper interface {
+	Load(key interface{}) (value interface{}, ok bool)
+	Store(key, value interface{})
+	LoadOrStore(key, value interface{}) (actual interface{}, loaded bool)
+	LoadAndDelete(key interface{}) (value interface{}, loaded bool)
+	Delete(key interface{})
+	Range(f func(key, value interface{}) bool)
+}
+
+// compile check
+var (
+	_ SyncMapper = (*sync.Map)(nil)
+	_ SyncMapper = (*SyncMap)(nil)
+)
+
+// SyncMap is drop-in replacement of sync.Map backed by sharded map.
+// key must be comparable, same as sync.Map.
+type SyncMap struct {
+	shards []*syncMapShard
+	mask   uint64
+	hash   CMapHashFunc
+}
+
+// NewSyncMap uses WithSlabSize, WithCacheCapacity and WithHashFunc same as Map, other options are ignored.
+func NewSyncMap(funcs ...cmapOptionFunc) *SyncMap {
+	opt := newMapOption(funcs)
+	size := opt.shardCount()
+	shards := make([]*syncMapShard, size)
+	for i := 0; i < size; i += 1 {
+		shards[i] = &syncMapShard{
+			mutex:  new(sync.RWMutex),
+			values: make(map[interface{}]interface{}, opt.cacheCapacity),
+		}
+	}
+	return &SyncMap{
+		shards: shards,
+		mask:   uint64(size - 1),
+		hash:   opt.mapHashFunc(),
+	}
+}
+
+func (s *SyncMap) getShard(key interface{}) *syncMapShard {
+	return s.shards[mixShard(hashComparable(s.hash, key))&s.mask]
+}
+
+func (s *SyncMap) Load(key interface{}) (interface{}, bool) {
+	m := s.getShard(key)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	v, ok := m.values[key]
+	return v, ok
+}
+
+func (s *SyncMap) Store(key, value interface{}) {
+	m := s.getShard(key)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.values[key] = value
+}
+
+func (s *SyncMap) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	m := s.getShard(key)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if v, ok := m.values[key]; ok {
+		return v, true
+	}
+	m.values[key] = value
+	return value, false
+}
+
+func (s *SyncMap) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	m := s.getShard(key)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	v, ok := m.values[key]
+	delete(m.values, key)
+	return v, ok
+}
+
+func (s *SyncMap) Delete(key interface{}) {
+	s.LoadAndDelete(key)
+}
+
+// Range iterates copy of each shard, f may call any method on the map same as sync.Map.
+func (s *SyncMap) Range(f func(key, value interface{}) bool) {
+	keys := make([]interface{}, 0, 64)
+	values := make([]interface{}, 0, 64)
+	for _, m := range s.shards {
+		keys, values = keys[:0], values[:0]
+
+		m.mutex.RLock()
+		for k, v := range m.values {
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+		m.mutex.RUnlock()
+
+		for i := range keys {
+			if f(keys[i], values[i]) != true {
+				return
+			}
+		}
+	}
+}
+
+// syncMapShard holds interface{} keys, which Map[K, V] can not take as comparable type parameter
+type syncMapShard struct {
+	mutex  *sync.RWMutex
+	values map[interface{}]interface{}
+}
@@ -0,0 +1,117 @@
+package cmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func BenchmarkSyncMapper(b *testing.B) {
+	keys := make([]string, 10000)
+	for i := 0; i < len(keys); i += 1 {
+		keys[i] = strconv.Itoa(i)
+	}
+	run := func(tb *testing.B, m SyncMapper) {
+		tb.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				key := keys[i%len(keys)]
+				if i%4 == 0 {
+					m.Store(key, i)
+				} else {
+					m.Load(key)
+				}
+				i += 1
+			}
+		})
+	}
+
+	b.Run("sync.Map", func(tb *testing.B) {
+		run(tb, new(sync.Map))
+	})
+	b.Run("SyncMap", func(tb *testing.B) {
+		run(tb, NewSyncMap())
+	})
+}
+
+func TestSyncMapper(t *testing.T) {
+	testMapper := func(tt *testing.T, m SyncMapper) {
+		if _, ok := m.Load("foo"); ok {
+			tt.Errorf("foo not exists")
+		}
+
+		m.Store("foo", "bar")
+		m.Store(1, "int key")
+		m.Store(struct{ a, b int }{1, 2}, "struct key")
+
+		if v, ok := m.Load("foo"); ok != true || v.(string) != "bar" {
+			tt.Errorf("foo = bar: %v", v)
+		}
+		if v, ok := m.Load(1); ok != true || v.(string) != "int key" {
+			tt.Errorf("1 = int key: %v", v)
+		}
+		if _, ok := m.Load("1"); ok {
+			tt.Errorf("string 1 is different from int 1")
+		}
+		if v, ok := m.Load(struct{ a, b int }{1, 2}); ok != true || v.(string) != "struct key" {
+			tt.Errorf("struct key: %v", v)
+		}
+
+		if actual, loaded := m.LoadOrStore("foo", "baz"); loaded != true || actual.(string) != "bar" {
+			tt.Errorf("loaded bar: %v", actual)
+		}
+		if actual, loaded := m.LoadOrStore("hello", "world"); loaded || actual.(string) != "world" {
+			tt.Errorf("stored world: %v", actual)
+		}
+
+		if v, loaded := m.LoadAndDelete("hello"); loaded != true || v.(string) != "world" {
+			tt.Errorf("deleted world: %v", v)
+		}
+		if _, loaded := m.LoadAndDelete("hello"); loaded {
+			tt.Errorf("already deleted")
+		}
+
+		m.Delete(1)
+		if _, ok := m.Load(1); ok {
+			tt.Errorf("1 deleted")
+		}
+
+		count := 0
+		m.Range(func(key, value interface{}) bool {
+			// modify map in callback
+			m.Store(key, value)
+			count += 1
+			return true
+		})
+		if count != 2 {
+			tt.Errorf("foo and struct key: %d", count)
+		}
+	}
+
+	t.Run("sync.Map", func(tt *testing.T) {
+		testMapper(tt, new(sync.Map))
+	})
+	t.Run("SyncMap", func(tt *testing.T) {
+		testMapper(tt, NewSyncMap())
+	})
+}
+
+func TestSyncMapOption(t *testing.T) {
+	s := NewSyncMap(WithSlabSize(4), WithHashFunc(NewFNV64HashFun()))
+	if len(s.shards) != 4 {
+		t.Errorf("slab size 4: %d", len(s.shards))
+	}
+
+	s = NewSyncMap(WithMaxEntries(10))
+	for i := 0; i < 100; i += 1 {
+		s.Store(i, i)
+	}
+	n := 0
+	s.Range(func(key, value interface{}) bool {
+		n += 1
+		return true
+	})
+	if n != 100 {
+		t.Errorf("WithMaxEntries ignored: %d", n)
+	}
+}
@@ -0,0 +1,161 @@
+package cmap
+
+import (
+	"errors"
+)
+
+var (
+	ErrKeyNotInTx = errors.New("cmap: key is not declared in transaction")
+)
+
+type TxFunc func(tx *Txn) error
+
+type txWrite struct {
+	value  interface{}
+	remove bool
+}
+
+// Txn buffers writes, applied to the map only when TxFunc returns nil.
+type Txn struct {
+	c      *CMap
+	shards map[string]*shard
+	writes map[string]txWrite
+	order  []string
+	err    error
+}
+
+func (tx *Txn) shard(key string) (*shard, bool) {
+	m, ok := tx.shards[key]
+	if ok != true && tx.err == nil {
+		tx.err = ErrKeyNotInTx
+	}
+	return m, ok
+}
+
+func (tx *Txn) Get(key string) (interface{}, bool) {
+	m, ok := tx.shard(key)
+	if ok != true {
+		return nil, false
+	}
+	if w, ok := tx.writes[key]; ok {
+		if w.remove {
+			return nil, false
+		}
+		return w.value, true
+	}
+	return m.Get(key)
+}
+
+func (tx *Txn) Set(key string, value interface{}) {
+	if _, ok := tx.shard(key); ok != true {
+		return
+	}
+	tx.write(key, txWrite{value: value})
+}
+
+func (tx *Txn) Remove(key string) (interface{}, bool) {
+	v, ok := tx.Get(key)
+	if tx.err != nil {
+		return nil, false
+	}
+	tx.write(key, txWrite{remove: true})
+	return v, ok
+}
+
+func (tx *Txn) write(key string, w txWrite) {
+	if _, ok := tx.writes[key]; ok != true {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = w
+}
+
+// commit applies writes, they are logged as one record when write-ahead log is attached
+func (tx *Txn) commit(locked []*shard) {
+	var w *writeAheadLog
+	if 0 < len(locked) {
+		w = locked[0].wal
+	}
+	if w != nil {
+		batch := new(walBatch)
+		for _, m := range locked {
+			m.batch = batch
+		}
+		defer func() {
+			for _, m := range locked {
+				m.batch = nil
+			}
+			w.writeBatch(batch)
+		}()
+	}
+
+	for _, key := range tx.order {
+		m := tx.shards[key]
+		w := tx.writes[key]
+		if w.remove {
+			m.Remove(key)
+		} else {
+			tx.c.setValue(m, key, w.value)
+		}
+	}
+}
+
+// Tx locks all shards of keys in index order, and commits writes of fn atomically.
+// when fn returns error or accesses undeclared key, all writes are discarded.
+func (c *CMap) Tx(keys []string, fn TxFunc) error {
+	s := c.enterSlab()
+	defer c.leaveSlab()
+
+	groups := s.groupByShard(keys)
+	shards := s.Shards()
+	locked := make([]*shard, len(groups))
+	tx := &Txn{
+		c:      c,
+		shards: make(map[string]*shard, len(keys)),
+		writes: make(map[string]txWrite, len(keys)),
+		order:  make([]string, 0, len(keys)),
+	}
+	for i, g := range groups {
+		m := shards[g.index]
+		locked[i] = m
+		for _, p := range g.positions {
+			tx.shards[keys[p]] = m
+		}
+	}
+
+	for _, m := range locked {
+		m.Lock()
+	}
+	defer c.unlockAll(locked)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if tx.err != nil {
+		return tx.err
+	}
+	tx.commit(locked)
+	return nil
+}
+
+// unlockAll releases all shard locks, then notifies evicted entries
+func (c *CMap) unlockAll(shards []*shard) {
+	var w *writeAheadLog
+	evicted := make([]evictedEntry, 0)
+	for i := len(shards) - 1; 0 <= i; i -= 1 {
+		evicted = append(evicted, shards[i].takeEvicted()...)
+		w = shards[i].wal
+		shards[i].Unlock()
+	}
+
+	for _, e := range evicted {
+		c.onEvict(e.key, e.value, e.reason)
+	}
+	if w != nil {
+		w.reportError()
+	}
+	if 0 < len(shards) {
+		if b := shards[0].budget; b != nil && b.over() {
+			c.evictOverBudget(b, nil)
+		}
+	}
+}
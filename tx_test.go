@@ -0,0 +1,129 @@
+package cmap
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestCmapTx(t *testing.T) {
+	t.Run("commit", func(tt *testing.T) {
+		c := New(WithSlabSize(8))
+		c.Set("from", 100)
+
+		err := c.Tx([]string{"from", "to"}, func(tx *Txn) error {
+			v, ok := tx.Remove("from")
+			if ok != true {
+				return errors.New("from not found")
+			}
+			if _, ok := tx.Get("from"); ok {
+				tt.Errorf("removed in tx")
+			}
+			tx.Set("to", v)
+			if v, _ := tx.Get("to"); v.(int) != 100 {
+				tt.Errorf("read own write")
+			}
+			return nil
+		})
+		if err != nil {
+			tt.Errorf("no error: %+v", err)
+		}
+		if _, ok := c.Get("from"); ok {
+			tt.Errorf("from moved")
+		}
+		if v, ok := c.Get("to"); ok != true || v.(int) != 100 {
+			tt.Errorf("to = 100: %v", v)
+		}
+	})
+	t.Run("rollback", func(tt *testing.T) {
+		c := New(WithSlabSize(8))
+		c.Set("a", 1)
+		c.Set("b", 2)
+
+		errRollback := errors.New("rollback")
+		err := c.Tx([]string{"a", "b"}, func(tx *Txn) error {
+			tx.Set("a", 10)
+			tx.Remove("b")
+			return errRollback
+		})
+		if err != errRollback {
+			tt.Errorf("returns fn error: %+v", err)
+		}
+		if v, _ := c.Get("a"); v.(int) != 1 {
+			tt.Errorf("a not updated")
+		}
+		if v, _ := c.Get("b"); v.(int) != 2 {
+			tt.Errorf("b not removed")
+		}
+	})
+	t.Run("undeclared", func(tt *testing.T) {
+		c := New(WithSlabSize(8))
+
+		err := c.Tx([]string{"a"}, func(tx *Txn) error {
+			tx.Set("a", 1)
+			tx.Set("b", 2)
+			return nil
+		})
+		if err != ErrKeyNotInTx {
+			tt.Errorf("undeclared key: %+v", err)
+		}
+		if c.Len() != 0 {
+			tt.Errorf("rollback")
+		}
+	})
+	t.Run("concurrent", func(tt *testing.T) {
+		c := New(WithSlabSize(4))
+		keys := make([]string, 10)
+		for i := 0; i < len(keys); i += 1 {
+			keys[i] = strconv.Itoa(i)
+			c.Set(keys[i], 100)
+		}
+
+		wg := new(sync.WaitGroup)
+		for i := 0; i < 8; i += 1 {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+
+				for j := 0; j < 200; j += 1 {
+					from, to := keys[(n+j)%len(keys)], keys[(n+j+3)%len(keys)]
+					c.Tx([]string{to, from}, func(tx *Txn) error {
+						f, _ := tx.Get(from)
+						t, _ := tx.Get(to)
+						tx.Set(from, f.(int)-1)
+						tx.Set(to, t.(int)+1)
+						return nil
+					})
+				}
+			}(i)
+		}
+		for i := 0; i < 20; i += 1 {
+			sum := 0
+			for _, v := range c.Snapshot() {
+				sum += v.(int)
+			}
+			if sum != 1000 {
+				tt.Errorf("total is always 1000: %d", sum)
+			}
+		}
+		wg.Wait()
+	})
+	t.Run("evict", func(tt *testing.T) {
+		var c *CMap
+		c = New(WithSlabSize(8), WithOnEvict(func(key string, value interface{}, reason EvictReason) {
+			// all shard locks are released
+			c.Set("evicted/"+key, value)
+		}))
+		c.Set("a", 1)
+		c.Set("b", 2)
+		c.Tx([]string{"a", "b"}, func(tx *Txn) error {
+			tx.Remove("a")
+			tx.Remove("b")
+			return nil
+		})
+		if c.Len() != 2 {
+			tt.Errorf("evicted/a evicted/b: %v", c.Keys())
+		}
+	})
+}
@@ -0,0 +1,731 @@
+package cmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Write-ahead log file format (version 1).
+// integers are unsigned varint unless noted, checksums are CRC-32 (Castagnoli) in big endian.
+//
+//	header:
+//	  magic        "CWAL" (4 bytes)
+//	  version      1 byte
+//	  codec name   length + bytes
+//	  checksum     crc32 of the header fields above (4 bytes)
+//	record (repeated):
+//	  length       payload length (4 bytes)
+//	  checksum     crc32 of length (4 bytes)
+//	  payload:
+//	    op         1 byte, 1 = set, 2 = remove, 3 = batch
+//	    set or remove:
+//	      key        length + bytes
+//	      expire at  unix nano of expiration, 0 = no expiration (set only)
+//	      value      length + codec encoded bytes (set only)
+//	    batch:
+//	      count      number of records
+//	      record     length + payload of set or remove (repeated count times)
+//	  checksum     crc32 of payload (4 bytes)
+//
+// Compact rotates the log to "<path>.old", saves "<path>.snapshot" by SaveTo and removes the old log.
+// Open replays snapshot, old log and log in this order, incomplete record at the tail of log is dropped.
+const (
+	walMagic        = "CWAL"
+	walVersion byte = 1
+
+	walOpSet    byte = 1
+	walOpRemove byte = 2
+	walOpBatch  byte = 3
+
+	walRecordHeaderSize = 8
+	maxWALRecordSize    = math.MaxInt32 - 4
+)
+
+var (
+	ErrWALFormat   = errors.New("cmap: invalid write-ahead log format")
+	ErrWALVersion  = errors.New("cmap: unsupported write-ahead log version")
+	ErrWALChecksum = errors.New("cmap: write-ahead log checksum mismatch")
+	ErrWALCodec    = errors.New("cmap: write-ahead log codec mismatch")
+	ErrWALClosed   = errors.New("cmap: write-ahead log closed")
+	ErrNoWAL       = errors.New("cmap: map is not opened with write-ahead log")
+)
+
+type WALSyncPolicy uint8
+
+const (
+	// WALSyncAlways fsyncs every mutation before it returns
+	WALSyncAlways WALSyncPolicy = iota + 1
+	// WALSyncInterval fsyncs periodically, mutations since last fsync may be lost on OS crash
+	WALSyncInterval
+	// WALSyncNever leaves fsync to OS
+	WALSyncNever
+)
+
+// WALErrorFunc is called when write-ahead log stops by an encode or write error.
+// mutations after that are applied to the map only, Sync and Close return the error.
+type WALErrorFunc func(err error)
+
+type writeAheadLog struct {
+	mutex   *sync.Mutex
+	compact *sync.Mutex
+	path    string
+	file    *os.File
+	codec   Codec
+	policy  WALSyncPolicy
+	dirty   bool
+	err     error
+	onError WALErrorFunc
+	failed  uint32
+}
+
+// walBatch collects records of Tx, they are written as one record so that replay applies all or nothing
+type walBatch struct {
+	payloads [][]byte
+}
+
+func (w *writeAheadLog) snapshotPath() string {
+	return w.path + ".snapshot"
+}
+
+func (w *writeAheadLog) oldPath() string {
+	return w.path + ".old"
+}
+
+func (w *writeAheadLog) appendSet(key string, value interface{}, expireAt int64, batch *walBatch) {
+	data, err := w.codec.Marshal(value)
+	if err != nil {
+		w.fail(fmt.Errorf("cmap: encode value of key %s: %w", key, err))
+		return
+	}
+	payload := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(key)+binary.MaxVarintLen64+len(data))
+	payload = append(payload, walOpSet)
+	payload = appendRaw(payload, []byte(key))
+	payload = appendUvarint(payload, uint64(expireAt))
+	payload = appendRaw(payload, data)
+	w.append(payload, batch)
+}
+
+func (w *writeAheadLog) appendRemove(key string, batch *walBatch) {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64+len(key))
+	payload = append(payload, walOpRemove)
+	payload = appendRaw(payload, []byte(key))
+	w.append(payload, batch)
+}
+
+func (w *writeAheadLog) append(payload []byte, batch *walBatch) {
+	if batch != nil {
+		batch.payloads = append(batch.payloads, payload)
+		return
+	}
+	w.write(payload)
+}
+
+// writeBatch writes records collected by batch as one record
+func (w *writeAheadLog) writeBatch(batch *walBatch) {
+	switch len(batch.payloads) {
+	case 0:
+		return
+	case 1:
+		w.write(batch.payloads[0])
+		return
+	}
+
+	size := 1 + binary.MaxVarintLen64
+	for _, p := range batch.payloads {
+		size += binary.MaxVarintLen64 + len(p)
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, walOpBatch)
+	payload = appendUvarint(payload, uint64(len(batch.payloads)))
+	for _, p := range batch.payloads {
+		payload = appendRaw(payload, p)
+	}
+	w.write(payload)
+}
+
+func (w *writeAheadLog) fail(err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.setErr(err)
+}
+
+// setErr keeps the first error that stops logging, caller holds mutex
+func (w *writeAheadLog) setErr(err error) {
+	if err == nil || w.err != nil {
+		return
+	}
+	w.err = err
+	if w.onError != nil {
+		atomic.StoreUint32(&w.failed, 1)
+	}
+}
+
+// reportError calls onError once with the error that stopped logging, caller must not hold shard locks
+func (w *writeAheadLog) reportError() {
+	if atomic.CompareAndSwapUint32(&w.failed, 1, 0) != true {
+		return
+	}
+	w.mutex.Lock()
+	err := w.err
+	w.mutex.Unlock()
+
+	w.onError(err)
+}
+
+// write appends record, once an error occurred log stops to not leave gap in it
+func (w *writeAheadLog) write(payload []byte) {
+	record := make([]byte, 0, walRecordHeaderSize+len(payload)+4)
+	record = appendUint32(record, uint32(len(payload)))
+	record = appendUint32(record, crc32.Checksum(record, crcTable))
+	record = append(record, payload...)
+	record = appendUint32(record, crc32.Checksum(payload, crcTable))
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return
+	}
+	if _, err := w.file.Write(record); err != nil {
+		w.setErr(err)
+		return
+	}
+	if w.policy == WALSyncAlways {
+		w.setErr(w.file.Sync())
+		return
+	}
+	w.dirty = true
+}
+
+func (w *writeAheadLog) sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if w.dirty {
+		w.dirty = false
+		w.setErr(w.file.Sync())
+	}
+	return w.err
+}
+
+func (w *writeAheadLog) close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Sync()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	if w.err == nil {
+		w.err = ErrWALClosed
+		return err
+	}
+	return w.err
+}
+
+// rotate moves current log to old log and starts new log.
+// if old log remains by failed compaction, current log is appended to it.
+func (w *writeAheadLog) rotate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(w.oldPath()); err == nil {
+		if err := appendWALFile(w.oldPath(), w.path, w.codec.Name()); err != nil {
+			w.setErr(err)
+			return err
+		}
+	} else {
+		if err := os.Rename(w.path, w.oldPath()); err != nil {
+			w.setErr(err)
+			return err
+		}
+	}
+
+	f, err := createWALFile(w.path, w.codec.Name())
+	if err != nil {
+		w.setErr(err)
+		return err
+	}
+	w.file = f
+	w.dirty = false
+	return syncDir(w.path)
+}
+
+func appendWALFile(dst, src string, codecName string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	cr := &countReader{r: in}
+	br := bufio.NewReader(cr)
+	if err := readWALHeader(br, codecName); err != nil {
+		return err
+	}
+	if _, err := in.Seek(cr.n-int64(br.Buffered()), io.SeekStart); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func createWALFile(path string, codecName string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, 64)
+	header = append(header, walMagic...)
+	header = append(header, walVersion)
+	header = appendRaw(header, []byte(codecName))
+	header = appendUint32(header, crc32.Checksum(header, crcTable))
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func readWALHeader(r *bufio.Reader, codecName string) error {
+	header := make([]byte, len(walMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return unexpectedEOF(err)
+	}
+	if string(header[:len(walMagic)]) != walMagic {
+		return ErrWALFormat
+	}
+	if header[len(walMagic)] != walVersion {
+		return fmt.Errorf("%w: %d", ErrWALVersion, header[len(walMagic)])
+	}
+	nameLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if 0xff < nameLen {
+		return ErrWALFormat
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return unexpectedEOF(err)
+	}
+	header = appendRaw(header, name)
+
+	sum := make([]byte, 4)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return unexpectedEOF(err)
+	}
+	if binary.BigEndian.Uint32(sum) != crc32.Checksum(header, crcTable) {
+		return ErrWALChecksum
+	}
+	if string(name) != codecName {
+		return fmt.Errorf("%w: %s != %s", ErrWALCodec, name, codecName)
+	}
+	return nil
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// replayWAL applies records of log at path, returns offset of the end of last valid record.
+// incomplete or corrupted record at the tail (e.g. crash while writing) ends the replay,
+// corrupted record followed by other records is ErrWALChecksum.
+func (c *CMap) replayWAL(path string, codec Codec) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	cr := &countReader{r: f}
+	br := bufio.NewReader(cr)
+	if err := readWALHeader(br, codec.Name()); err != nil {
+		return 0, err
+	}
+
+	for {
+		offset := cr.n - int64(br.Buffered())
+		payload, err := readWALRecord(br)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			if _, perr := br.Peek(1); perr == io.EOF {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("%w: record at offset %d of %s", err, offset, path)
+		}
+		entries, err := decodeWALRecord(payload, codec)
+		if err != nil {
+			return offset, err
+		}
+		for _, e := range entries {
+			c.applyWALEntry(e)
+		}
+	}
+}
+
+// readWALRecord returns payload of record, io.EOF at the end of log and io.ErrUnexpectedEOF on incomplete record
+func readWALRecord(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, walRecordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[4:]) != crc32.Checksum(header[:4], crcTable) {
+		return nil, ErrWALChecksum
+	}
+	size := binary.BigEndian.Uint32(header)
+	if maxWALRecordSize < size {
+		return nil, ErrWALFormat
+	}
+	record, err := readChunked(r, int(size)+4)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	payload := record[:size]
+	if binary.BigEndian.Uint32(record[size:]) != crc32.Checksum(payload, crcTable) {
+		return nil, ErrWALChecksum
+	}
+	return payload, nil
+}
+
+type walEntry struct {
+	op       byte
+	key      string
+	value    interface{}
+	expireAt int64
+}
+
+// decodeWALRecord decodes all entries of record before any of them is applied
+func decodeWALRecord(payload []byte, codec Codec) ([]walEntry, error) {
+	if len(payload) < 1 {
+		return nil, ErrWALFormat
+	}
+	if payload[0] != walOpBatch {
+		e, err := decodeWALEntry(payload, codec)
+		if err != nil {
+			return nil, err
+		}
+		return []walEntry{e}, nil
+	}
+
+	count, n := binary.Uvarint(payload[1:])
+	if n <= 0 || uint64(len(payload)) < count {
+		return nil, ErrWALFormat
+	}
+	rest := payload[1+n:]
+	entries := make([]walEntry, 0, count)
+	for i := uint64(0); i < count; i += 1 {
+		p, next, err := readRaw(rest)
+		if err != nil {
+			return nil, ErrWALFormat
+		}
+		e, err := decodeWALEntry(p, codec)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+		rest = next
+	}
+	if len(rest) != 0 {
+		return nil, ErrWALFormat
+	}
+	return entries, nil
+}
+
+func decodeWALEntry(payload []byte, codec Codec) (walEntry, error) {
+	if len(payload) < 1 {
+		return walEntry{}, ErrWALFormat
+	}
+	op := payload[0]
+	key, rest, err := readRaw(payload[1:])
+	if err != nil {
+		return walEntry{}, ErrWALFormat
+	}
+
+	switch op {
+	case walOpRemove:
+		return walEntry{op: op, key: string(key)}, nil
+	case walOpSet:
+		expireAt, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return walEntry{}, ErrWALFormat
+		}
+		data, _, err := readRaw(rest[n:])
+		if err != nil {
+			return walEntry{}, ErrWALFormat
+		}
+		value, err := codec.Unmarshal(data)
+		if err != nil {
+			return walEntry{}, fmt.Errorf("cmap: decode value of key %s: %w", key, err)
+		}
+		return walEntry{op: op, key: string(key), value: value, expireAt: int64(expireAt)}, nil
+	}
+	return walEntry{}, ErrWALFormat
+}
+
+func (c *CMap) applyWALEntry(e walEntry) {
+	if e.op == walOpRemove {
+		c.Remove(e.key)
+		return
+	}
+	c.restore(e.key, e.value, e.expireAt)
+}
+
+// restore sets entry as recorded, without applying default TTL
+func (c *CMap) restore(key string, value interface{}, expireAt int64) {
+	m := c.lockShard(key)
+	defer c.unlock(m)
+
+	if expireAt == 0 {
+		m.Set(key, value)
+		return
+	}
+	if ttl := time.Until(time.Unix(0, expireAt)); 0 < ttl {
+		m.SetWithTTL(key, value, ttl)
+		return
+	}
+	m.Remove(key)
+}
+
+func syncDir(path string) error {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Open creates CMap backed by write-ahead log at path.
+// existing snapshot and logs are replayed, then every mutation is appended to the log before it returns.
+// replay restores entries as recorded without WithDefaultTTL, and does not call OnEvict.
+// fsync policy is set by WithWALSync, values are encoded by WithCodec.
+func Open(path string, funcs ...cmapOptionFunc) (*CMap, error) {
+	c := newCMap(funcs)
+
+	// overwrites while replay are not evictions, background goroutines are not started yet
+	onEvict := c.onEvict
+	c.onEvict = func(string, interface{}, EvictReason) {}
+	w, err := c.openWAL(path)
+	c.onEvict = onEvict
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.attachWAL(w)
+	c.start()
+
+	if _, err := os.Stat(w.oldPath()); err == nil {
+		if err := c.Compact(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *CMap) openWAL(path string) (*writeAheadLog, error) {
+	codec := c.opt.codec
+
+	if f, err := os.Open(path + ".snapshot"); err == nil {
+		err := c.LoadFrom(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if os.IsNotExist(err) != true {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path + ".old"); err == nil {
+		offset, err := c.replayWAL(path+".old", codec)
+		if err != nil {
+			return nil, err
+		}
+		// log is appended to old log by next compaction
+		if err := os.Truncate(path+".old", offset); err != nil {
+			return nil, err
+		}
+	}
+
+	var file *os.File
+	if _, err := os.Stat(path); err == nil {
+		offset, err := c.replayWAL(path, codec)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		// drop incomplete tail record
+		if err := f.Truncate(offset); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		file = f
+	} else {
+		f, err := createWALFile(path, codec.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := syncDir(path); err != nil {
+			f.Close()
+			return nil, err
+		}
+		file = f
+	}
+
+	return &writeAheadLog{
+		mutex:   new(sync.Mutex),
+		compact: new(sync.Mutex),
+		path:    path,
+		file:    file,
+		codec:   codec,
+		policy:  c.opt.walSyncPolicy,
+		onError: c.opt.onWALError,
+	}, nil
+}
+
+func (c *CMap) attachWAL(w *writeAheadLog) {
+	c.gate.beginResize()
+	defer c.gate.endResize()
+
+	c.wal = w
+	for _, m := range c.loadSlab().Shards() {
+		m.Lock()
+		m.wal = w
+		m.Unlock()
+	}
+
+	if w.policy == WALSyncInterval && 0 < c.opt.walSyncInterval {
+		c.wg.Add(1)
+		go c.runWALSync(c.opt.walSyncInterval)
+	}
+}
+
+func (c *CMap) runWALSync(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.wal.sync()
+			c.wal.reportError()
+		}
+	}
+}
+
+// Sync fsyncs write-ahead log, and returns the error that stopped logging if any
+func (c *CMap) Sync() error {
+	if c.wal == nil {
+		return ErrNoWAL
+	}
+	return c.wal.sync()
+}
+
+// Compact saves snapshot of the map and truncates write-ahead log.
+// mutations continue during compaction, they are appended to the new log.
+func (c *CMap) Compact() error {
+	if c.wal == nil {
+		return ErrNoWAL
+	}
+	w := c.wal
+
+	w.compact.Lock()
+	defer w.compact.Unlock()
+
+	if err := w.rotate(); err != nil {
+		return err
+	}
+
+	tmp := w.snapshotPath() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := c.SaveTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.snapshotPath()); err != nil {
+		return err
+	}
+	if err := syncDir(w.path); err != nil {
+		return err
+	}
+	if err := os.Remove(w.oldPath()); err != nil {
+		return err
+	}
+	return syncDir(w.path)
+}
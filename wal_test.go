@@ -0,0 +1,452 @@
+package cmap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWALReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	c, err := Open(path, WithSlabSize(4))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	c.Set("foo", "bar")
+	c.Set("remove", 1)
+	c.Remove("remove")
+	c.Upsert("counter", func(exists bool, v interface{}) interface{} {
+		return 1
+	})
+	c.SetIf("counter", func(exists bool, v interface{}) (interface{}, bool) {
+		return v.(int) + 1, true
+	})
+	c.Set("removeif", 1)
+	c.RemoveIf("removeif", func(exists bool, v interface{}) bool {
+		return true
+	})
+	c.SetWithTTL("ttl", 1, time.Hour)
+	c.SetWithTTL("expired", 1, 10*time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	r, err := Open(path, WithSlabSize(16))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	defer r.Close()
+
+	if r.Len() != 3 {
+		t.Errorf("foo, counter, ttl remain: %v", r.Keys())
+	}
+	if v, _ := r.Get("foo"); v != "bar" {
+		t.Errorf("foo = bar: %v", v)
+	}
+	if v, _ := r.Get("counter"); v != 2 {
+		t.Errorf("counter = 2: %v", v)
+	}
+	for _, key := range []string{"remove", "removeif", "expired"} {
+		if _, ok := r.Get(key); ok {
+			t.Errorf("%s not exists", key)
+		}
+	}
+}
+
+func TestWALCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	c, err := Open(path)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	for i := 0; i < 100; i += 1 {
+		c.Set(strconv.Itoa(i), i)
+	}
+	// not closed, and partially written record at the tail
+	stat, _ := os.Stat(path)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{30, walOpSet, 3, 'f'})
+	f.Close()
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if r.Len() != 100 {
+		t.Errorf("all acknowledged writes replayed: %d", r.Len())
+	}
+	if s, _ := os.Stat(path); s.Size() != stat.Size() {
+		t.Errorf("incomplete record truncated: %d != %d", s.Size(), stat.Size())
+	}
+	r.Set("foo", "bar")
+	r.Close()
+
+	r2, err := Open(path)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	defer r2.Close()
+	if v, _ := r2.Get("foo"); v != "bar" || r2.Len() != 101 {
+		t.Errorf("appended after truncated tail: %v %d", v, r2.Len())
+	}
+}
+
+func TestWALCorrupted(t *testing.T) {
+	// offsets of the end of records
+	setup := func(tt *testing.T, path string) []int64 {
+		c, err := Open(path)
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		ends := make([]int64, 0, 10)
+		for i := 0; i < 10; i += 1 {
+			c.Set(strconv.Itoa(i), i)
+			stat, _ := os.Stat(path)
+			ends = append(ends, stat.Size())
+		}
+		c.Close()
+		return ends
+	}
+	corrupt := func(path string, offset int64) {
+		f, _ := os.OpenFile(path, os.O_RDWR, 0)
+		b := make([]byte, 1)
+		f.ReadAt(b, offset)
+		b[0] ^= 0xff
+		f.WriteAt(b, offset)
+		f.Close()
+	}
+
+	t.Run("payload", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "test.wal")
+		ends := setup(tt, path)
+		corrupt(path, ends[2]-5)
+
+		if _, err := Open(path); errors.Is(err, ErrWALChecksum) != true {
+			tt.Errorf("corrupted record followed by records: %v", err)
+		}
+		if s, _ := os.Stat(path); s.Size() != ends[9] {
+			tt.Errorf("not truncated: %d", s.Size())
+		}
+	})
+	t.Run("length", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "test.wal")
+		ends := setup(tt, path)
+		corrupt(path, ends[2])
+
+		if _, err := Open(path); errors.Is(err, ErrWALChecksum) != true {
+			tt.Errorf("corrupted length followed by records: %v", err)
+		}
+		if s, _ := os.Stat(path); s.Size() != ends[9] {
+			tt.Errorf("not truncated: %d", s.Size())
+		}
+	})
+	t.Run("tail", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "test.wal")
+		ends := setup(tt, path)
+		corrupt(path, ends[9]-5)
+
+		r, err := Open(path)
+		if err != nil {
+			tt.Fatalf("corrupted tail dropped: %+v", err)
+		}
+		defer r.Close()
+		if r.Len() != 9 {
+			tt.Errorf("records before tail replayed: %d", r.Len())
+		}
+		if s, _ := os.Stat(path); s.Size() != ends[8] {
+			tt.Errorf("tail truncated: %d", s.Size())
+		}
+	})
+}
+
+func TestWALTx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	c, err := Open(path, WithSlabSize(4))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	c.Set("d", 0)
+	before, _ := os.Stat(path)
+	err = c.Tx([]string{"a", "b", "c", "d"}, func(tx *Txn) error {
+		tx.Set("a", 1)
+		tx.Set("b", 2)
+		tx.Set("c", 3)
+		tx.Remove("d")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	after, _ := os.Stat(path)
+	c.Close()
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	for key, expect := range map[string]int{"a": 1, "b": 2, "c": 3} {
+		if v, _ := r.Get(key); v != expect {
+			t.Errorf("%s replayed: %v", key, v)
+		}
+	}
+	if _, ok := r.Get("d"); ok {
+		t.Errorf("d removed")
+	}
+	r.Close()
+
+	// torn batch record is dropped entirely
+	if err := os.Truncate(path, after.Size()-1); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	r2, err := Open(path)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	defer r2.Close()
+	if r2.Len() != 1 {
+		t.Errorf("no write of tx replayed: %v", r2.Keys())
+	}
+	if s, _ := os.Stat(path); s.Size() != before.Size() {
+		t.Errorf("batch is one record: %d != %d", s.Size(), before.Size())
+	}
+}
+
+func TestWALCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	c, err := Open(path, WithWALSync(WALSyncNever, 0))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	for i := 0; i < 1000; i += 1 {
+		c.Set("key", i)
+	}
+	before, _ := os.Stat(path)
+	if err := c.Compact(); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	after, _ := os.Stat(path)
+	if before.Size() <= after.Size() {
+		t.Errorf("log truncated: %d -> %d", before.Size(), after.Size())
+	}
+	if _, err := os.Stat(path + ".snapshot"); err != nil {
+		t.Errorf("snapshot saved: %+v", err)
+	}
+	if _, err := os.Stat(path + ".old"); os.IsNotExist(err) != true {
+		t.Errorf("old log removed: %+v", err)
+	}
+	c.Set("foo", "bar")
+	c.Close()
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	defer r.Close()
+	if v, _ := r.Get("key"); v != 999 {
+		t.Errorf("key restored from snapshot: %v", v)
+	}
+	if v, _ := r.Get("foo"); v != "bar" {
+		t.Errorf("foo restored from log: %v", v)
+	}
+}
+
+func TestWALReplayAsRecorded(t *testing.T) {
+	hasTTL := func(c *CMap, key string) bool {
+		m := c.rlockShard(key)
+		defer m.RUnlock()
+
+		_, ok := m.Cache.(ttlCache).TTL(key)
+		return ok
+	}
+
+	for _, compact := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.wal")
+		c, err := Open(path, WithDefaultTTL(time.Hour))
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		c.SetWithTTL("forever", 1, 0)
+		c.Set("replaced", 1)
+		c.Set("replaced", 2)
+		if compact {
+			if err := c.Compact(); err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+		}
+		c.Close()
+
+		evicted := 0
+		r, err := Open(path, WithDefaultTTL(time.Hour), WithOnEvict(func(key string, value interface{}, reason EvictReason) {
+			evicted += 1
+		}))
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if hasTTL(r, "forever") {
+			t.Errorf("no expiry kept, compact=%v", compact)
+		}
+		if hasTTL(r, "replaced") != true {
+			t.Errorf("default ttl recorded, compact=%v", compact)
+		}
+		if evicted != 0 {
+			t.Errorf("replay does not call OnEvict, compact=%v: %d", compact, evicted)
+		}
+		r.Set("replaced", 3)
+		if evicted != 1 {
+			t.Errorf("OnEvict after replay, compact=%v: %d", compact, evicted)
+		}
+		r.Close()
+	}
+}
+
+func TestWALCompactInterrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	c, err := Open(path)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	c.Set("foo", 1)
+	c.Set("bar", 1)
+	// crash after rotate, before snapshot
+	if err := c.wal.rotate(); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	c.Set("foo", 2)
+	c.Remove("bar")
+	if err := c.wal.rotate(); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	c.Set("baz", 3)
+	c.Close()
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	defer r.Close()
+	if v, _ := r.Get("foo"); v != 2 {
+		t.Errorf("foo = 2: %v", v)
+	}
+	if _, ok := r.Get("bar"); ok {
+		t.Errorf("bar removed")
+	}
+	if v, _ := r.Get("baz"); v != 3 {
+		t.Errorf("baz = 3: %v", v)
+	}
+	if _, err := os.Stat(path + ".old"); os.IsNotExist(err) != true {
+		t.Errorf("old log compacted on open: %+v", err)
+	}
+}
+
+func TestWALSyncPolicy(t *testing.T) {
+	for _, policy := range []WALSyncPolicy{WALSyncAlways, WALSyncInterval, WALSyncNever} {
+		path := filepath.Join(t.TempDir(), "test.wal")
+		c, err := Open(path, WithWALSync(policy, 10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		c.Set("foo", "bar")
+		time.Sleep(20 * time.Millisecond)
+		if err := c.Sync(); err != nil {
+			t.Errorf("no error: %+v", err)
+		}
+		if err := c.Close(); err != nil {
+			t.Errorf("no error: %+v", err)
+		}
+	}
+}
+
+func TestWALError(t *testing.T) {
+	t.Run("nowal", func(tt *testing.T) {
+		c := New()
+		if err := c.Sync(); errors.Is(err, ErrNoWAL) != true {
+			tt.Errorf("no wal: %v", err)
+		}
+		if err := c.Compact(); errors.Is(err, ErrNoWAL) != true {
+			tt.Errorf("no wal: %v", err)
+		}
+	})
+	t.Run("codec", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "test.wal")
+		c, err := Open(path)
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		c.Close()
+		if _, err := Open(path, WithCodec(NewJSONCodec())); errors.Is(err, ErrWALCodec) != true {
+			tt.Errorf("codec mismatch: %v", err)
+		}
+	})
+	t.Run("encode", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "test.wal")
+		c, err := Open(path, WithCodec(NewRawCodec()))
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		defer c.Close()
+		c.Set("foo", 1)
+		if err := c.Sync(); errors.Is(err, ErrCodecUnsupportedType) != true {
+			tt.Errorf("logging stopped by encode error: %v", err)
+		}
+	})
+	t.Run("onerror", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "test.wal")
+		var c *CMap
+		errs := make([]error, 0)
+		c, err := Open(path, WithCodec(NewRawCodec()), WithOnWALError(func(err error) {
+			// called outside the shard lock
+			c.Len()
+			errs = append(errs, err)
+		}))
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		defer c.Close()
+		c.Set("foo", "bar")
+		if len(errs) != 0 {
+			tt.Errorf("no error: %v", errs)
+		}
+		c.Set("foo", 1)
+		c.Set("bar", 2)
+		if len(errs) != 1 || errors.Is(errs[0], ErrCodecUnsupportedType) != true {
+			tt.Errorf("reported once: %v", errs)
+		}
+	})
+	t.Run("closed", func(tt *testing.T) {
+		path := filepath.Join(tt.TempDir(), "test.wal")
+		c, err := Open(path)
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		c.Close()
+		c.Set("foo", "bar")
+		if err := c.Sync(); errors.Is(err, ErrWALClosed) != true {
+			tt.Errorf("closed: %v", err)
+		}
+	})
+}
+
+func BenchmarkWAL(b *testing.B) {
+	for _, policy := range []WALSyncPolicy{WALSyncInterval, WALSyncNever} {
+		b.Run(strconv.Itoa(int(policy)), func(tb *testing.B) {
+			c, err := Open(filepath.Join(tb.TempDir(), "bench.wal"), WithWALSync(policy, time.Second))
+			if err != nil {
+				tb.Fatalf("no error: %+v", err)
+			}
+			defer c.Close()
+			tb.ResetTimer()
+			for i := 0; i < tb.N; i += 1 {
+				c.Set(strconv.Itoa(i&1023), i)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package cmap
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type EventType uint8
+
+const (
+	EventSet EventType = iota + 1
+	EventUpdate
+	EventDelete
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+type Event struct {
+	Type     EventType
+	Key      string
+	OldValue interface{}
+	NewValue interface{}
+}
+
+// WatchDropPolicy decides which event to drop when subscriber buffer is full,
+// writers never block on slow subscribers.
+type WatchDropPolicy uint8
+
+const (
+	WatchDropNewest WatchDropPolicy = iota
+	WatchDropOldest
+)
+
+type Subscription struct {
+	hub     *watchHub
+	key     string
+	prefix  bool
+	ch      chan Event
+	policy  WatchDropPolicy
+	dropped uint64
+	once    sync.Once
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.hub.unsubscribe(s)
+	})
+}
+
+func (s *Subscription) send(ev Event) {
+	select {
+	case s.ch <- ev:
+		return
+	default:
+	}
+
+	if s.policy == WatchDropOldest {
+		select {
+		case <-s.ch:
+		default:
+		}
+		select {
+		case s.ch <- ev:
+		default:
+		}
+	}
+	atomic.AddUint64(&s.dropped, 1)
+}
+
+type watchHub struct {
+	mutex      *sync.RWMutex
+	keys       map[string][]*Subscription
+	prefixes   []*Subscription
+	count      int32
+	bufferSize int
+	policy     WatchDropPolicy
+}
+
+func (h *watchHub) active() bool {
+	return 0 < atomic.LoadInt32(&h.count)
+}
+
+func (h *watchHub) subscribe(key string, prefix bool) *Subscription {
+	s := &Subscription{
+		hub:    h,
+		key:    key,
+		prefix: prefix,
+		ch:     make(chan Event, h.bufferSize),
+		policy: h.policy,
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if prefix {
+		h.prefixes = append(h.prefixes, s)
+	} else {
+		h.keys[key] = append(h.keys[key], s)
+	}
+	atomic.AddInt32(&h.count, 1)
+	return s
+}
+
+func (h *watchHub) unsubscribe(s *Subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if s.prefix {
+		h.prefixes = removeSubscription(h.prefixes, s)
+	} else {
+		if subs := removeSubscription(h.keys[s.key], s); 0 < len(subs) {
+			h.keys[s.key] = subs
+		} else {
+			delete(h.keys, s.key)
+		}
+	}
+	atomic.AddInt32(&h.count, -1)
+	close(s.ch)
+}
+
+func removeSubscription(subs []*Subscription, s *Subscription) []*Subscription {
+	for i, sub := range subs {
+		if sub == s {
+			return append(subs[:i], subs[i+1:]...)
+		}
+	}
+	return subs
+}
+
+// emit is called while holding the shard lock, so events of a key are delivered in order
+func (h *watchHub) emit(ev Event) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, s := range h.keys[ev.Key] {
+		s.send(ev)
+	}
+	for _, s := range h.prefixes {
+		if strings.HasPrefix(ev.Key, s.key) {
+			s.send(ev)
+		}
+	}
+}
+
+func newWatchHub(bufferSize int, policy WatchDropPolicy) *watchHub {
+	return &watchHub{
+		mutex:      new(sync.RWMutex),
+		keys:       make(map[string][]*Subscription),
+		prefixes:   make([]*Subscription, 0),
+		bufferSize: bufferSize,
+		policy:     policy,
+	}
+}
+
+func (c *CMap) Watch(key string) *Subscription {
+	return c.watch.subscribe(key, false)
+}
+
+func (c *CMap) WatchPrefix(prefix string) *Subscription {
+	return c.watch.subscribe(prefix, true)
+}
@@ -0,0 +1,137 @@
+package cmap
+
+import (
+	"testing"
+	"time"
+)
+
+func testReceiveEvent(t *testing.T, s *Subscription) Event {
+	t.Helper()
+
+	select {
+	case ev := <-s.Events():
+		return ev
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("event not received")
+	}
+	return Event{}
+}
+
+func TestCmapWatch(t *testing.T) {
+	c := New()
+	s := c.Watch("foo")
+	defer s.Close()
+
+	c.Set("foo", "bar")
+	c.Set("other", "value")
+	c.Upsert("foo", func(exists bool, v interface{}) interface{} {
+		return v.(string) + "baz"
+	})
+	c.SetIf("foo", func(exists bool, v interface{}) (interface{}, bool) {
+		return "qux", true
+	})
+	c.Remove("foo")
+	c.Remove("foo")
+	c.Set("foo", 1)
+	c.RemoveIf("foo", func(exists bool, v interface{}) bool {
+		return true
+	})
+
+	expect := []Event{
+		{EventSet, "foo", nil, "bar"},
+		{EventUpdate, "foo", "bar", "barbaz"},
+		{EventUpdate, "foo", "barbaz", "qux"},
+		{EventDelete, "foo", "qux", nil},
+		{EventSet, "foo", nil, 1},
+		{EventDelete, "foo", 1, nil},
+	}
+	for i, e := range expect {
+		ev := testReceiveEvent(t, s)
+		if ev != e {
+			t.Errorf("[%d] expect %+v actual %+v", i, e, ev)
+		}
+	}
+	select {
+	case ev := <-s.Events():
+		t.Errorf("no more events: %+v", ev)
+	default:
+	}
+}
+
+func TestCmapWatchPrefix(t *testing.T) {
+	c := New()
+	s := c.WatchPrefix("config/")
+
+	c.Set("config/a", 1)
+	c.Set("other/a", 2)
+	c.Set("config/b", 3)
+
+	if ev := testReceiveEvent(t, s); ev.Key != "config/a" || ev.Type != EventSet {
+		t.Errorf("config/a set: %+v", ev)
+	}
+	if ev := testReceiveEvent(t, s); ev.Key != "config/b" || ev.Type != EventSet {
+		t.Errorf("config/b set: %+v", ev)
+	}
+
+	s.Close()
+	s.Close()
+	if _, ok := <-s.Events(); ok {
+		t.Errorf("channel closed")
+	}
+	if c.watch.active() {
+		t.Errorf("no subscription")
+	}
+	c.Set("config/c", 4)
+}
+
+func TestCmapWatchDropPolicy(t *testing.T) {
+	t.Run("newest", func(tt *testing.T) {
+		c := New(WithWatchBufferSize(2))
+		s := c.Watch("foo")
+		defer s.Close()
+
+		for i := 0; i < 5; i += 1 {
+			c.Set("foo", i)
+		}
+		if s.Dropped() != 3 {
+			tt.Errorf("3 events dropped: %d", s.Dropped())
+		}
+		if ev := testReceiveEvent(tt, s); ev.NewValue != 0 {
+			tt.Errorf("oldest event kept: %+v", ev)
+		}
+		if ev := testReceiveEvent(tt, s); ev.NewValue != 1 {
+			tt.Errorf("oldest event kept: %+v", ev)
+		}
+	})
+	t.Run("oldest", func(tt *testing.T) {
+		c := New(WithWatchBufferSize(2), WithWatchDropPolicy(WatchDropOldest))
+		s := c.Watch("foo")
+		defer s.Close()
+
+		for i := 0; i < 5; i += 1 {
+			c.Set("foo", i)
+		}
+		if s.Dropped() != 3 {
+			tt.Errorf("3 events dropped: %d", s.Dropped())
+		}
+		if ev := testReceiveEvent(tt, s); ev.NewValue != 3 {
+			tt.Errorf("newest event kept: %+v", ev)
+		}
+		if ev := testReceiveEvent(tt, s); ev.NewValue != 4 {
+			tt.Errorf("newest event kept: %+v", ev)
+		}
+	})
+}
+
+func TestEventTypeString(t *testing.T) {
+	for typ, expect := range map[EventType]string{
+		EventSet:     "set",
+		EventUpdate:  "update",
+		EventDelete:  "delete",
+		EventType(0): "unknown",
+	} {
+		if typ.String() != expect {
+			t.Errorf("expect %s actual %s", expect, typ.String())
+		}
+	}
+}